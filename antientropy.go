@@ -0,0 +1,215 @@
+package crdtex
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// The hash tree has merkleDepth levels below the root, each node has 1 << merkleFanoutBits children
+const (
+	merkleFanoutBits = 4
+	merkleDepth      = 3
+)
+
+// MerkleNode is the hash of the entries under a node of the hash tree over the keys of a State.
+// Level 0 is the root, the nodes of a level are numbered from 0
+type MerkleNode struct {
+	Level int
+	Index int
+	Hash  uint64
+}
+
+// AntiEntropyRequest is sent by AntiEntropy.AntiEntropy
+type AntiEntropyRequest struct {
+	// Nodes are compared with the same nodes of the hash tree of the receiver
+	Nodes []MerkleNode `json:",omitempty"`
+
+	// State contains the entries pushed to the receiver
+	State State `json:",omitempty"`
+}
+
+// AntiEntropyResponse is returned by AntiEntropy.AntiEntropy
+type AntiEntropyResponse struct {
+	// Nodes are the children of the requested inner nodes whose hashes differ
+	Nodes []MerkleNode `json:",omitempty"`
+
+	// State contains the entries under the requested leaves whose hashes differ
+	State State `json:",omitempty"`
+}
+
+// merkleTree contains the hashes of the nodes of each level,
+// the hash of a node is the XOR of the hashes of the entries under it
+type merkleTree [merkleDepth + 1][]uint64
+
+func merkleLeaf(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() & (1<<(merkleFanoutBits*merkleDepth) - 1))
+}
+
+func entryHash(key string, e Entry) uint64 {
+	var flags byte
+	if e.Suspect {
+		flags |= 1
+	}
+	if e.OutOfSync {
+		flags |= 2
+	}
+
+	var buf [25]byte
+	binary.BigEndian.PutUint64(buf[0:], e.Term)
+	binary.BigEndian.PutUint64(buf[8:], e.Timestamp)
+	binary.BigEndian.PutUint64(buf[16:], e.Version)
+	buf[24] = flags
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(key))
+	_, _ = h.Write([]byte{0})
+	_, _ = h.Write(buf[:])
+	_, _ = h.Write([]byte(e.Addr))
+	return h.Sum64()
+}
+
+func buildMerkleTree(state State) *merkleTree {
+	var t merkleTree
+	for level := range t {
+		t[level] = make([]uint64, 1<<(merkleFanoutBits*level))
+	}
+	for key, e := range state {
+		leaf := merkleLeaf(key)
+		h := entryHash(key, e)
+		for level := range t {
+			t[level][leaf>>(merkleFanoutBits*(merkleDepth-level))] ^= h
+		}
+	}
+	return &t
+}
+
+func (t *merkleTree) node(level int, index int) MerkleNode {
+	return MerkleNode{Level: level, Index: index, Hash: t[level][index]}
+}
+
+// differs reports whether n is a node of the tree with another hash
+func (t *merkleTree) differs(n MerkleNode) bool {
+	if n.Level < 0 || n.Level > merkleDepth || n.Index < 0 || n.Index >= len(t[n.Level]) {
+		return false
+	}
+	return t[n.Level][n.Index] != n.Hash
+}
+
+func (t *merkleTree) children(n MerkleNode) []MerkleNode {
+	first := n.Index << merkleFanoutBits
+	nodes := make([]MerkleNode, 0, 1<<merkleFanoutBits)
+	for i := first; i < first+1<<merkleFanoutBits; i++ {
+		nodes = append(nodes, t.node(n.Level+1, i))
+	}
+	return nodes
+}
+
+// differing returns the nodes of the tree at level with another hash than the ones of nodes
+func (t *merkleTree) differing(nodes []MerkleNode, level int) []MerkleNode {
+	var result []MerkleNode
+	for _, n := range nodes {
+		if n.Level == level && t.differs(n) {
+			result = append(result, t.node(n.Level, n.Index))
+		}
+	}
+	return result
+}
+
+// compare returns the children of the differing inner nodes, and the entries of the differing leaves
+func (t *merkleTree) compare(state State, nodes []MerkleNode) AntiEntropyResponse {
+	var resp AntiEntropyResponse
+	leaves := map[int]bool{}
+	for _, n := range nodes {
+		if !t.differs(n) {
+			continue
+		}
+		if n.Level < merkleDepth {
+			resp.Nodes = append(resp.Nodes, t.children(n)...)
+			continue
+		}
+		leaves[n.Index] = true
+	}
+	if len(leaves) > 0 {
+		resp.State = entriesOfLeaves(state, leaves, nil)
+	}
+	return resp
+}
+
+// entriesOfLeaves returns the entries under leaves that are not included in except
+func entriesOfLeaves(state State, leaves map[int]bool, except State) State {
+	result := State{}
+	for key, e := range state {
+		if !leaves[merkleLeaf(key)] {
+			continue
+		}
+		if other, ok := except[key]; ok && !entryLess(other, e) {
+			continue
+		}
+		result[key] = e
+	}
+	return result
+}
+
+// exchangeAntiEntropy descends the hash trees of state and of the state of addr level by level,
+// then pushes the entries of the differing leaves that addr does not have.
+// It returns the entries of the differing leaves of addr
+func exchangeAntiEntropy(ctx context.Context, ae AntiEntropy, addr string, state State) (State, error) {
+	tree := buildMerkleTree(state)
+	received := State{}
+	leaves := map[int]bool{}
+
+	nodes := []MerkleNode{tree.node(0, 0)}
+	for level := 1; len(nodes) > 0; level++ {
+		resp, err := ae.AntiEntropy(ctx, addr, AntiEntropyRequest{Nodes: nodes})
+		if err != nil {
+			return nil, err
+		}
+		for key, e := range resp.State {
+			received[key] = e
+		}
+
+		nodes = tree.differing(resp.Nodes, level)
+		if level == merkleDepth {
+			for _, n := range nodes {
+				leaves[n.Index] = true
+			}
+		}
+	}
+
+	push := entriesOfLeaves(state, leaves, received)
+	if len(push) > 0 {
+		if _, err := ae.AntiEntropy(ctx, addr, AntiEntropyRequest{State: push}); err != nil {
+			return nil, err
+		}
+	}
+	return received, nil
+}
+
+// AntiEntropy handles an anti-entropy request of another node: the pushed entries are merged,
+// and the hashes or the entries under the requested nodes that differ are returned
+func (r *Runner) AntiEntropy(ctx context.Context, req AntiEntropyRequest) (AntiEntropyResponse, error) {
+	var state State
+	if len(req.State) > 0 {
+		var err error
+		state, err = r.UpdateState(ctx, req.State)
+		if err != nil {
+			return AntiEntropyResponse{}, err
+		}
+	}
+	if len(req.Nodes) == 0 {
+		return AntiEntropyResponse{}, nil
+	}
+
+	if state == nil {
+		err := r.runAction(ctx, func(s *coreService, _ context.Context) {
+			state = s.getState()
+		})
+		if err != nil {
+			return AntiEntropyResponse{}, err
+		}
+	}
+	return buildMerkleTree(state).compare(state, req.Nodes), nil
+}
@@ -0,0 +1,182 @@
+package crdtex
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+// stateAntiEntropy answers anti-entropy requests with a local state
+type stateAntiEntropy struct {
+	state    State
+	requests int
+	pushed   State
+}
+
+var _ AntiEntropy = &stateAntiEntropy{}
+
+func (a *stateAntiEntropy) AntiEntropy(
+	_ context.Context, _ string, req AntiEntropyRequest,
+) (AntiEntropyResponse, error) {
+	a.requests++
+	if len(req.State) > 0 {
+		a.pushed = req.State
+		a.state = combineStates(a.state, req.State)
+	}
+	return buildMerkleTree(a.state).compare(a.state, req.Nodes), nil
+}
+
+func newLargeState(n int) State {
+	state := State{}
+	for i := 0; i < n; i++ {
+		state[fmt.Sprintf("node-%d", i)] = Entry{Term: 1, Timestamp: uint64(i), Version: 1}
+	}
+	return state
+}
+
+func TestMerkleTree(t *testing.T) {
+	t.Parallel()
+
+	a := newLargeState(100)
+	b := newLargeState(100)
+	assert.Equal(t, buildMerkleTree(a), buildMerkleTree(b))
+
+	b = b.putEntry("node-5", Entry{Term: 1, Timestamp: 5, Version: 2})
+	tree := buildMerkleTree(a)
+	otherTree := buildMerkleTree(b)
+	assert.NotEqual(t, tree[0][0], otherTree[0][0])
+
+	resp := otherTree.compare(b, []MerkleNode{tree.node(0, 0)})
+	assert.Equal(t, 16, len(resp.Nodes))
+	assert.Equal(t, 1, len(tree.differing(resp.Nodes, 1)))
+
+	leaf := merkleLeaf("node-5")
+	resp = otherTree.compare(b, []MerkleNode{tree.node(merkleDepth, leaf)})
+	assert.Equal(t, 0, len(resp.Nodes))
+	assert.Equal(t, Entry{Term: 1, Timestamp: 5, Version: 2}, resp.State["node-5"])
+
+	assert.Equal(t, AntiEntropyResponse{}, tree.compare(a, []MerkleNode{tree.node(0, 0)}))
+	assert.Equal(t, AntiEntropyResponse{}, tree.compare(a, []MerkleNode{{Level: 7, Index: 0, Hash: 1}}))
+}
+
+func TestExchangeAntiEntropy(t *testing.T) {
+	t.Parallel()
+
+	local := newLargeState(1000)
+	local = local.putEntry("node-1", Entry{Term: 1, Timestamp: 1, Version: 5})
+	local = local.putEntry("local-only", Entry{Term: 1, Timestamp: 2000, Version: 1})
+
+	remote := &stateAntiEntropy{state: newLargeState(1000)}
+	remote.state = remote.state.putEntry("node-2", Entry{Term: 1, Timestamp: 2, Version: 7})
+	remote.state = remote.state.putEntry("remote-only", Entry{Term: 1, Timestamp: 3000, Version: 1})
+
+	received, err := exchangeAntiEntropy(context.Background(), remote, "remote-addr", local)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, Entry{Term: 1, Timestamp: 2, Version: 7}, received["node-2"])
+	assert.Equal(t, Entry{Term: 1, Timestamp: 3000, Version: 1}, received["remote-only"])
+	assert.Less(t, len(received), 20)
+
+	assert.Equal(t, State{
+		"node-1":     {Term: 1, Timestamp: 1, Version: 5},
+		"local-only": {Term: 1, Timestamp: 2000, Version: 1},
+	}, remote.pushed)
+	assert.Equal(t, merkleDepth+2, remote.requests)
+
+	assert.Equal(t, remote.state, combineStates(local, received))
+}
+
+func TestExchangeAntiEntropy__Equal_States(t *testing.T) {
+	t.Parallel()
+
+	remote := &stateAntiEntropy{state: newLargeState(100)}
+	received, err := exchangeAntiEntropy(context.Background(), remote, "remote-addr", newLargeState(100))
+
+	assert.Equal(t, nil, err)
+	assert.Equal(t, State{}, received)
+	assert.Equal(t, 1, remote.requests)
+	assert.Equal(t, State(nil), remote.pushed)
+}
+
+func TestCoreService_AntiEntropy__Every_Rounds(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(methods, self,
+		computeOptions(
+			AddRemoteAddress("remote-addr-1"),
+			WithDeltaGossip(),
+			WithAntiEntropy(2),
+		),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+
+	s.init(context.Background())
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 2, len(methods.updateRemoteCalls()))
+	assert.Equal(t, 0, len(methods.antiEntropyCalls()))
+
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 2, len(methods.updateRemoteCalls()))
+	assert.Equal(t, 1, len(methods.antiEntropyCalls()))
+	assert.Equal(t, "remote-addr-1", methods.antiEntropyCalls()[0].Addr)
+	assert.Equal(t, s.getState(), methods.antiEntropyCalls()[0].State)
+
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+		},
+		partial: true,
+	})
+	assert.Equal(t, Entry{Term: 1, Timestamp: 80, Version: 1}, s.state.entry("remote-addr-1"))
+	_, acked := s.peerStates["remote-addr-1"]
+	assert.False(t, acked)
+}
+
+func TestRunner_AntiEntropy__Converge(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := []string{"node-1", "node-2", "node-3", "node-4"}
+	var runners []*Runner
+	for i, addr := range addrs {
+		r := net.newRunner(addr,
+			AddRemoteAddress(addrs[(i+1)%len(addrs)]),
+			WithSyncDuration(10*time.Millisecond),
+			WithExpireDuration(5*time.Second),
+			WithAntiEntropy(1),
+		)
+		runners = append(runners, r)
+		assert.Equal(t, nil, r.Start(ctx))
+	}
+
+	for i, r := range runners {
+		assert.Equal(t, nil, r.Set(ctx, fmt.Sprintf("key-%d", i), addrs[i]))
+	}
+	for _, r := range runners {
+		r := r
+		assert.Eventually(t, func() bool {
+			view := readClusterViewKV(ctx, r)
+			return len(view) == len(addrs) && view["key-3"] == "node-4"
+		}, 5*time.Second, 5*time.Millisecond)
+	}
+}
+
+func readClusterViewKV(ctx context.Context, r *Runner) map[string]string {
+	var kv map[string]string
+	_ = r.core.runAction(ctx, func(s *coreService, _ context.Context) {
+		kv = s.kv
+	})
+	return kv
+}
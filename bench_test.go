@@ -0,0 +1,139 @@
+package crdtex
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var benchClusterSizes = []int{10, 100, 1000}
+
+func newBenchState(n int) State {
+	state := State{}
+	for i := 0; i < n; i++ {
+		state[fmt.Sprintf("node-%d", i)] = Entry{Term: 1, Timestamp: uint64(i + 1), Version: 1}
+	}
+	return state
+}
+
+func BenchmarkStateTrie_MergeState(b *testing.B) {
+	for _, n := range benchClusterSizes {
+		state := newBenchState(n)
+		trie := newStateTrie(state)
+		changed := State{"node-0": {Term: 1, Timestamp: 1, Version: 2}}
+
+		b.Run(fmt.Sprintf("nodes=%d/unchanged", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				trie.mergeState(state)
+			}
+		})
+		b.Run(fmt.Sprintf("nodes=%d/one-changed", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				trie.mergeState(changed)
+			}
+		})
+	}
+}
+
+func BenchmarkCoreService_UpdateWithState(b *testing.B) {
+	for _, n := range benchClusterSizes {
+		b.Run(fmt.Sprintf("nodes=%d/full-unchanged", n), func(b *testing.B) {
+			s := newTestCoreService(newCallbacksMock(), WithExpireDuration(time.Hour))
+			s.init(context.Background())
+			s.updateWithState(newBenchState(n))
+			state := s.getState()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.updateWithState(state)
+			}
+		})
+		b.Run(fmt.Sprintf("nodes=%d/one-changed", n), func(b *testing.B) {
+			s := newTestCoreService(newCallbacksMock(), WithExpireDuration(time.Hour))
+			s.init(context.Background())
+			s.updateWithState(newBenchState(n))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.updateWithState(State{
+					"node-1": {Term: 1, Timestamp: 2, Version: uint64(i + 2)},
+				})
+			}
+		})
+	}
+}
+
+func BenchmarkComputeLeader(b *testing.B) {
+	for _, n := range benchClusterSizes {
+		b.Run(fmt.Sprintf("nodes=%d", n), func(b *testing.B) {
+			state := newBenchState(n)
+			trie := newStateTrie(state)
+			now := time.Now()
+			lastUpdate := map[string]time.Time{}
+			for key := range state {
+				lastUpdate[key] = now
+			}
+			detector := NewFixedFailureDetector(time.Minute)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				computeLeader(trie, "node-0", true, now, lastUpdate, detector)
+			}
+		})
+	}
+}
+
+func BenchmarkCoreService_CheckAndCallResetExpireTimer(b *testing.B) {
+	for _, n := range benchClusterSizes {
+		b.Run(fmt.Sprintf("nodes=%d", n), func(b *testing.B) {
+			s := newTestCoreService(newCallbacksMock(), WithExpireDuration(time.Hour))
+			s.init(context.Background())
+			s.updateWithState(newBenchState(n))
+			now := time.Now()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.checkAndCallResetExpireTimer(now, s.state)
+			}
+		})
+	}
+}
+
+// startBenchRunner runs a Runner without remote addresses, seeded with a state of n nodes
+func startBenchRunner(ctx context.Context, n int) *Runner {
+	net := newMemoryNetwork()
+	r := net.newRunner("node-0", WithExpireDuration(time.Hour))
+	if err := r.Start(ctx); err != nil {
+		panic(err)
+	}
+	r.Update(ctx, newBenchState(n))
+	return r
+}
+
+func BenchmarkRunner_Update(b *testing.B) {
+	for _, n := range benchClusterSizes {
+		b.Run(fmt.Sprintf("nodes=%d", n), func(b *testing.B) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r := startBenchRunner(ctx, n)
+
+			var version uint64
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					v := atomic.AddUint64(&version, 1)
+					r.Update(ctx, State{
+						"node-1": {Term: 1, Timestamp: 2, Version: v + 1},
+					})
+				}
+			})
+		})
+	}
+}
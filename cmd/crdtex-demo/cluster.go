@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/crdtex"
+	"github.com/QuangTung97/crdtex/httptransport"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const helpText = `commands:
+  list              show the nodes and the leader seen by each of them
+  kill <index>      stop a node
+  restart <index>   start a stopped node again, on the same address
+  help              show this help
+  quit              stop all nodes and exit
+`
+
+type node struct {
+	addr   string
+	peers  []string
+	runner *crdtex.Runner
+	server *http.Server
+	cancel func()
+	wg     sync.WaitGroup
+}
+
+type cluster struct {
+	conf   config
+	client *httptransport.Client
+
+	outMut sync.Mutex
+	out    io.Writer
+
+	nodes []*node // nil when killed
+	addrs []string
+}
+
+func newCluster(conf config, out io.Writer) *cluster {
+	return &cluster{
+		conf:   conf,
+		client: httptransport.NewClient(&http.Client{Timeout: conf.syncDuration}),
+		out:    out,
+	}
+}
+
+func (c *cluster) printf(format string, args ...interface{}) {
+	c.outMut.Lock()
+	defer c.outMut.Unlock()
+	_, _ = fmt.Fprintf(c.out, format, args...)
+}
+
+// launch reserves n localhost ports and starts a node on each of them
+func (c *cluster) launch(n int) error {
+	listeners := make([]net.Listener, 0, n)
+	for i := 0; i < n; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return err
+		}
+		listeners = append(listeners, l)
+		c.addrs = append(c.addrs, l.Addr().String())
+	}
+	c.nodes = make([]*node, n)
+
+	for i, l := range listeners {
+		c.nodes[i] = c.newNode(c.addrs[i], c.peersOf(c.addrs[i]))
+		c.nodes[i].start(c, l)
+	}
+	return nil
+}
+
+func (c *cluster) peersOf(addr string) []string {
+	var peers []string
+	for _, a := range c.addrs {
+		if a != addr {
+			peers = append(peers, a)
+		}
+	}
+	return peers
+}
+
+// startNode starts a node listening on addr
+func (c *cluster) startNode(addr string, peers []string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	n := c.newNode(l.Addr().String(), peers)
+	c.nodes = append(c.nodes, n)
+	c.addrs = append(c.addrs, n.addr)
+	n.start(c, l)
+	return nil
+}
+
+func (c *cluster) newNode(addr string, peers []string) *node {
+	n := &node{addr: addr, peers: peers}
+
+	options := []crdtex.Option{
+		crdtex.WithSyncDuration(c.conf.syncDuration),
+		crdtex.WithExpireDuration(c.conf.expireDuration),
+	}
+	for _, p := range peers {
+		options = append(options, crdtex.AddRemoteAddress(p))
+	}
+
+	transport := httptransport.Transport{
+		Client: c.client,
+		StartFunc: func(ctx context.Context) {
+			c.printf("[%s] started leading\n", addr)
+			<-ctx.Done()
+			c.printf("[%s] stopped leading\n", addr)
+		},
+	}
+	n.runner = crdtex.NewRunner(transport, addr, options...)
+	n.server = &http.Server{Handler: httptransport.NewHandler(n.runner, c.client)}
+	return n
+}
+
+func (n *node) start(c *cluster, l net.Listener) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n.cancel = cancel
+
+	// started before serving, so the handler never sees a runner not started
+	_ = n.runner.Start(ctx)
+
+	n.wg.Add(3)
+	go func() {
+		defer n.wg.Done()
+		_ = n.server.Serve(l)
+	}()
+	go func() {
+		defer n.wg.Done()
+		<-n.runner.Done()
+	}()
+	go func() {
+		defer n.wg.Done()
+		watcher := n.runner.NewLeaderWatcher()
+		for {
+			leader, err := watcher.Next(ctx)
+			if err != nil {
+				return
+			}
+			c.printf("[%s] leader is %s\n", n.addr, leader)
+		}
+	}()
+}
+
+func (n *node) stop() {
+	n.cancel()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = n.server.Shutdown(ctx)
+	n.wg.Wait()
+}
+
+func (c *cluster) stopAll() {
+	for i, n := range c.nodes {
+		if n != nil {
+			n.stop()
+			c.nodes[i] = nil
+		}
+	}
+}
+
+func (c *cluster) parseIndex(arg string) (int, error) {
+	i, err := strconv.Atoi(arg)
+	if err != nil || i < 0 || i >= len(c.nodes) {
+		return 0, fmt.Errorf("invalid node index %q", arg)
+	}
+	return i, nil
+}
+
+func (c *cluster) kill(arg string) error {
+	i, err := c.parseIndex(arg)
+	if err != nil {
+		return err
+	}
+	if c.nodes[i] == nil {
+		return fmt.Errorf("node %d is already stopped", i)
+	}
+	c.nodes[i].stop()
+	c.nodes[i] = nil
+	c.printf("[%s] killed\n", c.addrs[i])
+	return nil
+}
+
+func (c *cluster) restart(arg string) error {
+	i, err := c.parseIndex(arg)
+	if err != nil {
+		return err
+	}
+	if c.nodes[i] != nil {
+		return fmt.Errorf("node %d is running", i)
+	}
+
+	l, err := net.Listen("tcp", c.addrs[i])
+	if err != nil {
+		return err
+	}
+	c.nodes[i] = c.newNode(c.addrs[i], c.peersOf(c.addrs[i]))
+	c.nodes[i].start(c, l)
+	c.printf("[%s] restarted\n", c.addrs[i])
+	return nil
+}
+
+func (c *cluster) list() {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	for i, n := range c.nodes {
+		if n == nil {
+			c.printf("%d %s stopped\n", i, c.addrs[i])
+			continue
+		}
+		info, err := n.runner.DebugInfo(ctx)
+		if err != nil {
+			c.printf("%d %s error: %v\n", i, c.addrs[i], err)
+			continue
+		}
+		c.printf("%d %s leader=%s running=%t members=%d\n",
+			i, c.addrs[i], info.Leader, info.LeaderRunning, len(info.Members))
+	}
+}
+
+var errUnknownCommand = errors.New("unknown command, type help for the list of commands")
+
+type command struct {
+	numArgs int
+	run     func(c *cluster, args []string) error
+}
+
+var commands = map[string]command{
+	"help": {run: func(c *cluster, _ []string) error {
+		c.printf("%s", helpText)
+		return nil
+	}},
+	"list": {run: func(c *cluster, _ []string) error {
+		c.list()
+		return nil
+	}},
+	"kill": {numArgs: 1, run: func(c *cluster, args []string) error {
+		return c.kill(args[0])
+	}},
+	"restart": {numArgs: 1, run: func(c *cluster, args []string) error {
+		return c.restart(args[0])
+	}},
+}
+
+// handleCommand executes a line read from the user, returns true on quit
+func (c *cluster) handleCommand(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return false
+	}
+	if fields[0] == "quit" || fields[0] == "exit" {
+		return true
+	}
+
+	err := errUnknownCommand
+	if cmd, ok := commands[fields[0]]; ok && len(fields)-1 == cmd.numArgs {
+		err = cmd.run(c, fields[1:])
+	}
+	if err != nil {
+		c.printf("error: %v\n", err)
+	}
+	return false
+}
+
+// interact reads commands from in until quit, EOF or ctx is cancelled
+func (c *cluster) interact(ctx context.Context, in io.Reader) {
+	lines := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+		close(lines)
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok || c.handleCommand(line) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type syncBuffer struct {
+	mut sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.buf.String()
+}
+
+func newTestCluster(t *testing.T, n int) (*cluster, *syncBuffer) {
+	out := &syncBuffer{}
+	c := newCluster(config{
+		syncDuration:   20 * time.Millisecond,
+		expireDuration: 300 * time.Millisecond,
+	}, out)
+	assert.Equal(t, nil, c.launch(n))
+	t.Cleanup(c.stopAll)
+	return c, out
+}
+
+func leaderOf(c *cluster, i int) string {
+	info, err := c.nodes[i].runner.DebugInfo(context.Background())
+	if err != nil {
+		return ""
+	}
+	return info.Leader
+}
+
+func TestCluster_Kill_And_Restart(t *testing.T) {
+	t.Parallel()
+
+	c, out := newTestCluster(t, 3)
+
+	assert.Eventually(t, func() bool {
+		return leaderOf(c, 1) == c.addrs[0] && leaderOf(c, 2) == c.addrs[0]
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return strings.Contains(out.String(), "["+c.addrs[0]+"] started leading")
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, false, c.handleCommand("kill 0"))
+	assert.Equal(t, (*node)(nil), c.nodes[0])
+	assert.Eventually(t, func() bool {
+		return leaderOf(c, 1) == c.addrs[1] && leaderOf(c, 2) == c.addrs[1]
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, false, c.handleCommand("restart 0"))
+	assert.Eventually(t, func() bool {
+		return leaderOf(c, 0) == c.addrs[1]
+	}, 5*time.Second, 10*time.Millisecond)
+
+	output := out.String()
+	assert.True(t, strings.Contains(output, "["+c.addrs[0]+"] killed\n"))
+	assert.True(t, strings.Contains(output, "["+c.addrs[0]+"] restarted\n"))
+	assert.True(t, strings.Contains(output, "["+c.addrs[2]+"] leader is "+c.addrs[1]+"\n"))
+}
+
+func TestCluster_Handle_Command(t *testing.T) {
+	t.Parallel()
+
+	c, out := newTestCluster(t, 2)
+
+	table := []struct {
+		name   string
+		line   string
+		quit   bool
+		output string
+	}{
+		{name: "empty", line: "  "},
+		{name: "quit", line: "quit", quit: true},
+		{name: "help", line: "help", output: helpText},
+		{name: "unknown", line: "unknown", output: "error: " + errUnknownCommand.Error() + "\n"},
+		{name: "missing-index", line: "kill", output: "error: " + errUnknownCommand.Error() + "\n"},
+		{name: "invalid-index", line: "kill 5", output: "error: invalid node index \"5\"\n"},
+		{name: "restart-running", line: "restart 1", output: "error: node 1 is running\n"},
+	}
+
+	for _, e := range table {
+		before := len(out.String())
+		assert.Equal(t, e.quit, c.handleCommand(e.line), e.name)
+		assert.True(t, strings.Contains(out.String()[before:], e.output), e.name)
+	}
+
+	before := len(out.String())
+	c.handleCommand("list")
+	assert.True(t, strings.Contains(out.String()[before:], "0 "+c.addrs[0]+" leader="), out.String()[before:])
+}
+
+func TestRun_Interactive(t *testing.T) {
+	t.Parallel()
+
+	out := &syncBuffer{}
+	err := run(context.Background(), config{
+		nodes:          2,
+		syncDuration:   20 * time.Millisecond,
+		expireDuration: 300 * time.Millisecond,
+	}, strings.NewReader("kill 1\nquit\n"), out)
+
+	assert.Equal(t, nil, err)
+	assert.True(t, strings.Contains(out.String(), helpText))
+	assert.True(t, strings.Contains(out.String(), "] killed\n"))
+}
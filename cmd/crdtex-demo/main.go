@@ -0,0 +1,86 @@
+// Command crdtex-demo runs a local crdtex cluster over the HTTP transport.
+//
+// By default it launches N nodes in one process on localhost ports
+// and reads commands from stdin to kill and restart them:
+//
+//	crdtex-demo -nodes 5
+//
+// With -listen it runs a single node per process, so that a cluster can be spread over several terminals:
+//
+//	crdtex-demo -listen 127.0.0.1:7001 -peers 127.0.0.1:7002,127.0.0.1:7003
+//
+// Every node serves the endpoints of httptransport, so crdtexctl can be used against any of them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+)
+
+type config struct {
+	nodes  int
+	listen string
+	peers  string
+
+	syncDuration   time.Duration
+	expireDuration time.Duration
+}
+
+func main() {
+	var conf config
+	flag.IntVar(&conf.nodes, "nodes", 3, "number of nodes launched in this process")
+	flag.StringVar(&conf.listen, "listen", "", "run a single node listening on this address")
+	flag.StringVar(&conf.peers, "peers", "", "comma separated addresses of the other nodes, used with -listen")
+	flag.DurationVar(&conf.syncDuration, "sync", 500*time.Millisecond, "sync duration")
+	flag.DurationVar(&conf.expireDuration, "expire", 5*time.Second, "expire duration")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, conf, os.Stdin, os.Stdout); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, "crdtex-demo:", err)
+		os.Exit(1)
+	}
+}
+
+func splitPeers(peers string) []string {
+	var result []string
+	for _, p := range strings.Split(peers, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+func run(ctx context.Context, conf config, in io.Reader, out io.Writer) error {
+	c := newCluster(conf, out)
+	defer c.stopAll()
+
+	if conf.listen != "" {
+		if err := c.startNode(conf.listen, splitPeers(conf.peers)); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		return nil
+	}
+
+	if conf.nodes <= 0 {
+		return errors.New("-nodes must be positive")
+	}
+	if err := c.launch(conf.nodes); err != nil {
+		return err
+	}
+	c.printf("%s", helpText)
+
+	c.interact(ctx, in)
+	return nil
+}
@@ -0,0 +1,220 @@
+// Command crdtexctl inspects and controls the nodes of a crdtex cluster
+// through the endpoints of the httptransport package.
+//
+// Usage:
+//
+//	crdtexctl [-timeout 5s] members <addr>
+//	crdtexctl [-timeout 5s] leader <addr>
+//	crdtexctl [-timeout 5s] diff <addr1> <addr2>
+//	crdtexctl [-timeout 5s] step-down <addr>
+//	crdtexctl [-timeout 5s] leave <addr>
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/QuangTung97/crdtex"
+	"github.com/QuangTung97/crdtex/httptransport"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage: crdtexctl [-timeout duration] <command> <addr>...
+
+commands:
+  members <addr>          list the members seen by addr
+  leader <addr>           print the leader computed by addr
+  diff <addr1> <addr2>    compare the views of two nodes
+  step-down <addr>        ask addr to give up its leadership
+  leave <addr>            ask addr to leave the cluster
+`
+
+var errUsage = errors.New("invalid usage")
+
+func main() {
+	err := run(os.Args[1:], os.Stdout, os.Stderr, http.DefaultClient)
+	if err != nil {
+		if !errors.Is(err, errUsage) {
+			_, _ = fmt.Fprintln(os.Stderr, "crdtexctl:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+type command struct {
+	numArgs int
+	run     func(ctx context.Context, client *httptransport.Client, out io.Writer, args []string) error
+}
+
+var commands = map[string]command{
+	"members":   {numArgs: 1, run: runMembers},
+	"leader":    {numArgs: 1, run: runLeader},
+	"diff":      {numArgs: 2, run: runDiff},
+	"step-down": {numArgs: 1, run: runStepDown},
+	"leave":     {numArgs: 1, run: runLeave},
+}
+
+func run(args []string, stdout io.Writer, stderr io.Writer, httpClient *http.Client) error {
+	flags := flag.NewFlagSet("crdtexctl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() { _, _ = fmt.Fprint(stderr, usage) }
+	timeout := flags.Duration("timeout", 5*time.Second, "timeout of the whole command")
+
+	if err := flags.Parse(args); err != nil {
+		return errUsage
+	}
+
+	args = flags.Args()
+	if len(args) == 0 {
+		flags.Usage()
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok || len(args)-1 != cmd.numArgs {
+		flags.Usage()
+		return errUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	return cmd.run(ctx, httptransport.NewClient(httpClient), stdout, args[1:])
+}
+
+func memberStatus(m crdtex.DebugMember) string {
+	switch {
+	case m.OutOfSync:
+		return "out-of-sync"
+	case m.Suspect:
+		return "suspect"
+	default:
+		return "alive"
+	}
+}
+
+func formatAge(seconds *float64) string {
+	if seconds == nil {
+		return "-"
+	}
+	return strconv.FormatFloat(*seconds, 'f', 1, 64) + "s"
+}
+
+func runMembers(ctx context.Context, client *httptransport.Client, out io.Writer, args []string) error {
+	info, err := client.DebugInfo(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "ADDR\tTERM\tVERSION\tSTATUS\tLEADER\tLAST UPDATE\tLAST SYNC\tSYNC ERRORS")
+	for _, m := range info.Members {
+		leader := ""
+		if m.Addr == info.Leader {
+			leader = "*"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%d\n",
+			m.Addr, m.Term, m.Version, memberStatus(m), leader,
+			formatAge(m.LastUpdateAge), formatAge(m.LastSyncAge), m.SyncErrors)
+	}
+	return w.Flush()
+}
+
+func runLeader(ctx context.Context, client *httptransport.Client, out io.Writer, args []string) error {
+	info, err := client.DebugInfo(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(out, info.Leader)
+	return err
+}
+
+func formatMember(m crdtex.DebugMember, ok bool) string {
+	if !ok {
+		return "missing"
+	}
+	return fmt.Sprintf("term=%d timestamp=%d version=%d status=%s",
+		m.Term, m.Timestamp, m.Version, memberStatus(m))
+}
+
+func membersByAddr(info crdtex.DebugInfo) map[string]crdtex.DebugMember {
+	result := map[string]crdtex.DebugMember{}
+	for _, m := range info.Members {
+		result[m.Addr] = m
+	}
+	return result
+}
+
+func sameEntry(a, b crdtex.DebugMember) bool {
+	return a.Term == b.Term && a.Timestamp == b.Timestamp && a.Version == b.Version &&
+		a.Suspect == b.Suspect && a.OutOfSync == b.OutOfSync
+}
+
+func unionAddrs(a, b map[string]crdtex.DebugMember) []string {
+	var addrs []string
+	for addr := range a {
+		addrs = append(addrs, addr)
+	}
+	for addr := range b {
+		if _, existed := a[addr]; !existed {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	return addrs
+}
+
+func runDiff(ctx context.Context, client *httptransport.Client, out io.Writer, args []string) error {
+	infos := make([]crdtex.DebugInfo, 0, len(args))
+	for _, addr := range args {
+		info, err := client.DebugInfo(ctx, addr)
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+	}
+	first, second := membersByAddr(infos[0]), membersByAddr(infos[1])
+
+	diffs := 0
+	if infos[0].Leader != infos[1].Leader {
+		diffs++
+		_, _ = fmt.Fprintf(out, "leader:\n  %s: %s\n  %s: %s\n", args[0], infos[0].Leader, args[1], infos[1].Leader)
+	}
+	for _, addr := range unionAddrs(first, second) {
+		a, okA := first[addr]
+		b, okB := second[addr]
+		if okA && okB && sameEntry(a, b) {
+			continue
+		}
+		diffs++
+		_, _ = fmt.Fprintf(out, "%s:\n  %s: %s\n  %s: %s\n",
+			addr, args[0], formatMember(a, okA), args[1], formatMember(b, okB))
+	}
+
+	if diffs == 0 {
+		_, _ = fmt.Fprintln(out, "views are identical")
+	}
+	return nil
+}
+
+func runStepDown(ctx context.Context, client *httptransport.Client, out io.Writer, args []string) error {
+	if err := client.StepDown(ctx, args[0]); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%s stepped down\n", args[0])
+	return err
+}
+
+func runLeave(ctx context.Context, client *httptransport.Client, out io.Writer, args []string) error {
+	if err := client.Leave(ctx, args[0]); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(out, "%s left the cluster\n", args[0])
+	return err
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/QuangTung97/crdtex"
+	"github.com/QuangTung97/crdtex/httptransport"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type fakeNode struct {
+	mut     sync.Mutex
+	info    crdtex.DebugInfo
+	actions []string
+}
+
+func newFakeNode(t *testing.T, info crdtex.DebugInfo) (*fakeNode, string) {
+	n := &fakeNode{info: info}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(httptransport.PathDebug, func(w http.ResponseWriter, req *http.Request) {
+		_ = json.NewEncoder(w).Encode(n.info)
+	})
+	for _, path := range []string{httptransport.PathStepDown, httptransport.PathLeave} {
+		path := path
+		mux.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+			n.mut.Lock()
+			n.actions = append(n.actions, req.Method+" "+path)
+			n.mut.Unlock()
+		})
+	}
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return n, server.Listener.Addr().String()
+}
+
+func (n *fakeNode) getActions() []string {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	return n.actions
+}
+
+func runCommand(args ...string) (string, string, error) {
+	var stdout, stderr bytes.Buffer
+	err := run(args, &stdout, &stderr, http.DefaultClient)
+	return stdout.String(), stderr.String(), err
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestRun_Members(t *testing.T) {
+	t.Parallel()
+
+	_, addr := newFakeNode(t, crdtex.DebugInfo{
+		Self:   "node-1",
+		Leader: "node-1",
+		Members: []crdtex.DebugMember{
+			{Addr: "node-1", Term: 2, Version: 5, LastUpdateAge: floatPtr(0)},
+			{Addr: "node-2", Term: 1, Version: 3, Suspect: true, LastUpdateAge: floatPtr(1.25), SyncErrors: 2},
+			{Addr: "node-3", Term: 1, Version: 7, OutOfSync: true, LastSyncAge: floatPtr(3)},
+		},
+	})
+
+	stdout, _, err := runCommand("members", addr)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ""+
+		"ADDR    TERM  VERSION  STATUS       LEADER  LAST UPDATE  LAST SYNC  SYNC ERRORS\n"+
+		"node-1  2     5        alive        *       0.0s         -          0\n"+
+		"node-2  1     3        suspect              1.2s         -          2\n"+
+		"node-3  1     7        out-of-sync          -            3.0s       0\n",
+		stdout)
+}
+
+func TestRun_Leader(t *testing.T) {
+	t.Parallel()
+
+	_, addr := newFakeNode(t, crdtex.DebugInfo{Self: "node-2", Leader: "node-1"})
+
+	stdout, _, err := runCommand("-timeout", "1s", "leader", addr)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "node-1\n", stdout)
+}
+
+func TestRun_Diff(t *testing.T) {
+	t.Parallel()
+
+	_, addr1 := newFakeNode(t, crdtex.DebugInfo{
+		Leader: "node-1",
+		Members: []crdtex.DebugMember{
+			{Addr: "node-1", Term: 1, Timestamp: 10, Version: 5},
+			{Addr: "node-2", Term: 1, Timestamp: 20, Version: 3},
+		},
+	})
+	_, addr2 := newFakeNode(t, crdtex.DebugInfo{
+		Leader: "node-2",
+		Members: []crdtex.DebugMember{
+			{Addr: "node-1", Term: 1, Timestamp: 10, Version: 6, OutOfSync: true},
+			{Addr: "node-2", Term: 1, Timestamp: 20, Version: 3},
+			{Addr: "node-3", Term: 1, Timestamp: 30, Version: 1},
+		},
+	})
+
+	stdout, _, err := runCommand("diff", addr1, addr2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ""+
+		"leader:\n"+
+		"  "+addr1+": node-1\n"+
+		"  "+addr2+": node-2\n"+
+		"node-1:\n"+
+		"  "+addr1+": term=1 timestamp=10 version=5 status=alive\n"+
+		"  "+addr2+": term=1 timestamp=10 version=6 status=out-of-sync\n"+
+		"node-3:\n"+
+		"  "+addr1+": missing\n"+
+		"  "+addr2+": term=1 timestamp=30 version=1 status=alive\n",
+		stdout)
+}
+
+func TestRun_Diff__Identical(t *testing.T) {
+	t.Parallel()
+
+	info := crdtex.DebugInfo{
+		Leader:  "node-1",
+		Members: []crdtex.DebugMember{{Addr: "node-1", Term: 1, Timestamp: 10, Version: 5}},
+	}
+	_, addr1 := newFakeNode(t, info)
+	_, addr2 := newFakeNode(t, info)
+
+	stdout, _, err := runCommand("diff", addr1, addr2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "views are identical\n", stdout)
+}
+
+func TestRun_StepDown_And_Leave(t *testing.T) {
+	t.Parallel()
+
+	node, addr := newFakeNode(t, crdtex.DebugInfo{})
+
+	stdout, _, err := runCommand("step-down", addr)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, addr+" stepped down\n", stdout)
+
+	stdout, _, err = runCommand("leave", addr)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, addr+" left the cluster\n", stdout)
+
+	assert.Equal(t, []string{
+		"POST " + httptransport.PathStepDown,
+		"POST " + httptransport.PathLeave,
+	}, node.getActions())
+}
+
+func TestRun_Invalid_Usage(t *testing.T) {
+	t.Parallel()
+
+	table := []struct {
+		name string
+		args []string
+	}{
+		{name: "no-command", args: nil},
+		{name: "unknown-command", args: []string{"unknown", "addr"}},
+		{name: "missing-addr", args: []string{"diff", "addr"}},
+		{name: "invalid-flag", args: []string{"-unknown", "leader", "addr"}},
+	}
+
+	for _, e := range table {
+		e := e
+		t.Run(e.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, stderr, err := runCommand(e.args...)
+			assert.Equal(t, errUsage, err)
+			assert.True(t, strings.Contains(stderr, "usage: crdtexctl"))
+		})
+	}
+}
+
+func TestRun_Node_Error(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "context canceled", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, _, err := runCommand("leader", server.Listener.Addr().String())
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "status 503: context canceled"))
+}
@@ -2,14 +2,31 @@ package crdtex
 
 import (
 	"context"
+	"math/rand"
+	"sort"
 	"time"
 )
 
 const hundredYears = 100 * 365 * 24 * time.Hour
 
+// updateChanSize is the number of Update calls queued before the callers block
+const updateChanSize = 256
+
 type updateResult struct {
+	addr  string
 	state State
 	err   error
+
+	// sentAt is the time at which the request was sent, the remote node was alive after it
+	sentAt time.Time
+
+	// partial is true when state only contains the entries that differed
+	partial bool
+}
+
+type probeResult struct {
+	addr string
+	ok   bool
 }
 
 //go:generate moq -out core_mocks_test.go . callbacks
@@ -17,6 +34,8 @@ type updateResult struct {
 type callbacks interface {
 	start(ctx context.Context, finish chan<- struct{})
 	updateRemote(ctx context.Context, addr string, state State, resultChan chan<- updateResult)
+	probe(ctx context.Context, addr string, via []string, resultChan chan<- probeResult)
+	antiEntropy(ctx context.Context, addr string, state State, resultChan chan<- updateResult)
 }
 
 type updateRequest struct {
@@ -25,34 +44,99 @@ type updateRequest struct {
 }
 
 type coreService struct {
-	methods callbacks
-	self    nodeID
-	options serviceOptions
+	methods  callbacks
+	self     nodeID
+	selfAddr string
+	options  serviceOptions
 
 	getNow      func() time.Time
 	syncTimer   Timer
 	expireTimer Timer
+	leaseTimer  Timer
+	randIntn    func(n int) int
 
 	finishChan chan struct{}
 	cancel     func()
 
+	// closed when the core starts and stops running
+	started chan struct{}
+	stopped chan struct{}
+
 	// for outside in requests
 	updateChan chan updateRequest
 	// for inside out responses
 	updateResultChan chan updateResult
+	probeResultChan  chan probeResult
+
+	// the snapshot not written yet by the snapshot writer, closed on shutdown
+	snapshotChan chan Snapshot
+	snapshotDone chan struct{}
 
-	fetchLeaderChan chan fetchLeaderRequest
+	// for outside in queries
+	requestChan chan coreRequest
 
-	state         State
+	// the entries of the members, materialized as a State once per change by getState
+	state         stateTrie
+	snapshot      State
+	snapshotRoot  *trieNode
 	stateTerm     uint64
 	stateVersion  uint64
 	lastUpdate    map[string]time.Time
+	lastSynced    map[string]time.Time
+	suspected     map[string]time.Time
+	syncErrors    map[string]int
 	nextAddrIndex int
 
-	leader nodeID
+	// number of update and anti-entropy calls whose result is not received yet
+	pendingUpdates int
+
+	leader     nodeID
+	leaderAddr string
+
+	syncRounds   int
+	bootstrapped bool
+
+	// the remote addresses whose first exchange has not returned yet,
+	// with a HLC the seniority of this node is taken once they all have
+	joining map[string]bool
 
 	leaderWaitList  []chan<- string
 	runnerIsRunning bool
+	runnerStarts    int
+
+	left bool
+
+	// the timestamp at which this node started, unique among its restarts
+	incarnation uint64
+	// the term of this node when it started, its entries with a lower term
+	// or another timestamp were written by a previous incarnation
+	incarnationTerm uint64
+	// the last adopted entry of a previous incarnation
+	adopted    Entry
+	hasAdopted bool
+	// true once the entry of this node has been bumped
+	bumped bool
+	// the entries of previous incarnations replaced by the merges of the current update requests
+	replaced State
+
+	selfData   map[string]Register
+	lastWrite  uint64
+	kv         map[string]string
+	kvRevision uint64
+	kvWaitList []chan<- kvView
+
+	crdts     map[string]*crdtReplica
+	selfCRDTs map[string][]byte
+
+	peerStates map[string]State
+	entrySizes map[string]sizedEntry
+
+	lastSnapshot time.Time
+}
+
+// coreRequest is handled on the core goroutine
+type coreRequest interface {
+	handle(ctx context.Context, s *coreService)
 }
 
 type fetchLeaderRequest struct {
@@ -60,6 +144,30 @@ type fetchLeaderRequest struct {
 	respChan   chan<- string
 }
 
+var _ coreRequest = fetchLeaderRequest{}
+
+func (req fetchLeaderRequest) handle(_ context.Context, s *coreService) {
+	if req.lastLeader != s.leaderAddr {
+		notifyLeader(req.respChan, s.leaderAddr)
+		return
+	}
+	for _, waiter := range s.leaderWaitList {
+		if waiter == req.respChan {
+			// still waiting since a cancelled call
+			return
+		}
+	}
+	s.leaderWaitList = append(s.leaderWaitList, req.respChan)
+}
+
+// notifyLeader sends leader without blocking, a cancelled watcher is still holding a previous leader
+func notifyLeader(waiter chan<- string, leader string) {
+	select {
+	case waiter <- leader:
+	default:
+	}
+}
+
 type leaderWatcher struct {
 	core *coreService
 	ch   chan string
@@ -69,33 +177,54 @@ func newCoreService(
 	methods callbacks, selfID nodeID, options serviceOptions,
 ) *coreService {
 	finishChan := make(chan struct{}, 1)
-	updateChan := make(chan updateRequest, 256)
+	updateChan := make(chan updateRequest, updateChanSize)
 	updateResultChan := make(chan updateResult, 16)
-	fetchLeaderChan := make(chan fetchLeaderRequest, 128)
+	probeResultChan := make(chan probeResult, 16)
+	requestChan := make(chan coreRequest, 128)
 	return &coreService{
-		methods: methods,
-		self:    selfID,
-		options: options,
+		methods:  methods,
+		self:     selfID,
+		selfAddr: selfID.addr,
+
+		incarnation: selfID.timestamp,
+		options:     options,
 
 		getNow:      func() time.Time { return time.Now() },
 		syncTimer:   newTimer(),
 		expireTimer: newTimer(),
+		leaseTimer:  newTimer(),
+		randIntn:    rand.Intn,
 
 		finishChan:       finishChan,
+		started:          make(chan struct{}),
+		stopped:          make(chan struct{}),
 		updateChan:       updateChan,
 		updateResultChan: updateResultChan,
-		fetchLeaderChan:  fetchLeaderChan,
+		probeResultChan:  probeResultChan,
+		requestChan:      requestChan,
+
+		snapshotChan: make(chan Snapshot, 1),
+		snapshotDone: make(chan struct{}),
 
 		lastUpdate:    map[string]time.Time{},
+		lastSynced:    map[string]time.Time{},
+		suspected:     map[string]time.Time{},
+		syncErrors:    map[string]int{},
 		nextAddrIndex: 0,
+
+		crdts: newCRDTReplicas(options.crdts),
+
+		peerStates: map[string]State{},
+		entrySizes: map[string]sizedEntry{},
 	}
 }
 
-func (s *coreService) checkAndCallResetExpireTimer(now time.Time, newState State) State {
+func (s *coreService) checkAndCallResetExpireTimer(now time.Time, newState stateTrie) stateTrie {
 	minAddr := ""
-	minUpdate := now.AddDate(100, 0, 0)
+	minDeadline := now.AddDate(100, 0, 0)
+	var expired State
 	for addr, t := range s.lastUpdate {
-		entry, ok := newState[addr]
+		entry, ok := newState.get(addr)
 		if !ok {
 			panic("must be true")
 		}
@@ -104,131 +233,677 @@ func (s *coreService) checkAndCallResetExpireTimer(now time.Time, newState State
 			continue
 		}
 
-		if !t.Add(s.options.expireDuration).After(now) {
+		deadline := s.expireDeadline(addr, t)
+		if !deadline.After(now) {
 			entry.OutOfSync = true
-			newState = newState.putEntry(addr, entry)
+			if expired == nil {
+				expired = State{}
+			}
+			expired[addr] = entry
+			s.options.metrics.MemberExpired(addrOf(newState, addr))
+			s.options.logger.Warn("member out of sync", "addr", addrOf(newState, addr), "lastUpdate", t)
 			continue
 		}
 
-		if minUpdate.After(t) {
-			minUpdate = t
+		if minDeadline.After(deadline) {
+			minDeadline = deadline
 			minAddr = addr
 		}
 	}
 
 	if minAddr != "" {
-		s.expireTimer.Reset(minUpdate.Add(s.options.expireDuration).Sub(now))
+		s.expireTimer.Reset(minDeadline.Sub(now))
+	}
+	for addr, entry := range expired {
+		newState = newState.putEntry(addr, entry)
 	}
 	return newState
 }
 
+// expireDeadline returns the deadline of the failure detector,
+// or the end of the suspect timeout if addr is suspected
+func (s *coreService) expireDeadline(addr string, lastUpdate time.Time) time.Time {
+	deadline := s.options.failureDetector.Deadline(addr, lastUpdate)
+	suspectedAt, ok := s.suspected[addr]
+	if !ok || s.options.suspectTimeout == 0 {
+		return deadline
+	}
+	suspectDeadline := suspectedAt.Add(s.options.suspectTimeout)
+	if suspectDeadline.Before(deadline) {
+		return suspectDeadline
+	}
+	return deadline
+}
+
 func (s *coreService) updateWithState(inputState State) {
+	mergeStart := time.Now()
 	now := s.getNow()
 
-	newState := combineStates(s.state, inputState)
-	for newAddr, newEntry := range newState {
+	s.observeClock(inputState)
+	newState, changed := s.state.mergeState(inputState)
+	for _, newAddr := range changed {
 		if newAddr == s.self.addr {
 			continue
 		}
 
-		old, existed := s.state[newAddr]
-		if !existed {
-			s.lastUpdate[newAddr] = now
+		newEntry := newState.entry(newAddr)
+		s.recordReplaced(newAddr, newEntry)
+		s.lastUpdate[newAddr] = now
+		if newEntry.Suspect {
+			s.startSuspicion(newAddr, now)
 			continue
 		}
-		if old != newEntry {
-			s.lastUpdate[newAddr] = now
-		}
+		s.options.failureDetector.Heartbeat(newAddr, now)
+		delete(s.suspected, newAddr)
 	}
 
 	newState = s.checkAndCallResetExpireTimer(now, newState)
+	oldState := s.state
 	s.state = newState
+	s.adoptPreviousSelf(inputState)
+	s.mergeData(oldState)
+	s.mergeCRDTs(oldState)
+
+	if self := s.state.entry(s.self.addr); self.Suspect {
+		// refute the suspicion
+		s.options.logger.Debug("refuting suspicion", "version", self.Version)
+		s.bumpSelfEntry()
+	}
+
+	s.options.metrics.MergeDuration(time.Since(mergeStart))
+	s.options.metrics.StateSize(s.state.len())
+}
+
+// recordReplaced records the entry of key when newEntry is written by another incarnation of the node
+func (s *coreService) recordReplaced(key string, newEntry Entry) {
+	old, existed := s.state.get(key)
+	if !existed || old.Timestamp == newEntry.Timestamp {
+		return
+	}
+	if s.replaced == nil {
+		s.replaced = State{}
+	}
+	if _, recorded := s.replaced[key]; !recorded {
+		s.replaced[key] = old
+	}
+}
+
+// handleUpdateRequests merges the state of req and of the requests already queued behind it.
+// They all receive the same response, so that the state is materialized once per batch
+func (s *coreService) handleUpdateRequests(ctx context.Context, req updateRequest) {
+	s.replaced = nil
+	s.updateWithState(req.state)
+
+	queued := len(s.updateChan)
+	respChans := make([]chan<- State, 0, queued+1)
+	respChans = append(respChans, req.respChan)
+	for i := 0; i < queued; i++ {
+		next := <-s.updateChan
+		s.updateWithState(next.state)
+		respChans = append(respChans, next.respChan)
+	}
+
+	s.computeAndStartLeader(ctx)
+	resp := s.updateResponse()
+	for _, respChan := range respChans {
+		respChan <- resp
+	}
+}
+
+// updateResponse returns the state with the entries replaced by the merges of the update requests,
+// so that a restarted node receives the entry of its previous incarnation
+func (s *coreService) updateResponse() State {
+	state := s.getState()
+	if len(s.replaced) == 0 {
+		return state
+	}
+	result := make(State, len(state))
+	for k, v := range state {
+		result[k] = v
+	}
+	for k, v := range s.replaced {
+		result[k] = v
+	}
+	return result
+}
+
+// observeClock updates the hybrid logical clock with the timestamps and versions of state
+func (s *coreService) observeClock(state State) {
+	if s.options.clock == nil {
+		return
+	}
+	for _, e := range state {
+		s.options.clock.Update(e.Timestamp)
+		s.options.clock.Update(e.Version)
+	}
+}
+
+// startSuspicion starts the suspect timeout of addr if it is not already suspected
+func (s *coreService) startSuspicion(addr string, now time.Time) {
+	if _, existed := s.suspected[addr]; existed {
+		return
+	}
+	s.suspected[addr] = now
 }
 
 func (s *coreService) callUpdateRemote(ctx context.Context, addr string) {
-	s.methods.updateRemote(ctx, addr, s.state, s.updateResultChan)
+	s.options.metrics.SyncAttempted(addr)
+	s.pendingUpdates++
+	s.methods.updateRemote(ctx, addr, s.stateToSend(addr), s.updateResultChan)
+}
+
+// syncRemote exchanges the state with addr, through anti-entropy every antiEntropyRounds sync rounds
+func (s *coreService) syncRemote(ctx context.Context, addr string) {
+	rounds := s.options.antiEntropyRounds
+	if rounds == 0 || s.syncRounds%rounds != 0 {
+		s.callUpdateRemote(ctx, addr)
+		return
+	}
+	s.options.metrics.SyncAttempted(addr)
+	s.pendingUpdates++
+	s.methods.antiEntropy(ctx, addr, s.getState(), s.updateResultChan)
+}
+
+// isMember returns true if addr is the address of a member in sync or a remote address
+func (s *coreService) isMember(addr string) bool {
+	for _, remoteAddr := range s.options.remoteAddresses {
+		if remoteAddr == addr {
+			return true
+		}
+	}
+	key, existed := keyOf(s.state, addr)
+	return existed && !s.state.entry(key).OutOfSync
+}
+
+// clusterSize is the number of members in sync plus the remote addresses not seen yet,
+// the members that have left or expired are not counted
+func (s *coreService) clusterSize() int {
+	size := 0
+	s.state.each(func(_ string, e Entry) {
+		if !e.OutOfSync {
+			size++
+		}
+	})
+	for _, addr := range s.options.remoteAddresses {
+		if _, existed := keyOf(s.state, addr); !existed {
+			size++
+		}
+	}
+	return size
+}
+
+// leaseExpiry returns the time at which the leader lease will be lost,
+// the lease is held while a majority of the cluster (self included) has been
+// successfully synced with in the last leaseDuration, measured from the time the requests were sent
+func (s *coreService) leaseExpiry(now time.Time) (time.Time, bool) {
+	if s.options.leaseDuration == 0 {
+		return now.Add(hundredYears), true
+	}
+
+	needed := s.clusterSize() / 2
+	if needed == 0 {
+		return now.Add(hundredYears), true
+	}
+
+	minTime := now.Add(-s.options.leaseDuration)
+	var syncTimes []time.Time
+	for addr, t := range s.lastSynced {
+		if addr == s.selfAddr {
+			continue
+		}
+		if key, existed := keyOf(s.state, addr); existed && s.state.entry(key).OutOfSync {
+			continue
+		}
+		if t.After(minTime) {
+			syncTimes = append(syncTimes, t)
+		}
+	}
+	if len(syncTimes) < needed {
+		return time.Time{}, false
+	}
+
+	sort.Slice(syncTimes, func(i, j int) bool {
+		return syncTimes[i].After(syncTimes[j])
+	})
+	return syncTimes[needed-1].Add(s.options.leaseDuration), true
+}
+
+func (s *coreService) holdLease(now time.Time) bool {
+	expiry, ok := s.leaseExpiry(now)
+	if !ok {
+		return false
+	}
+	if s.options.leaseDuration > 0 {
+		s.leaseTimer.Reset(expiry.Sub(now))
+	}
+	return true
 }
 
 func (s *coreService) startLeader(ctx context.Context) {
-	// TODO tests
-	if !s.runnerIsRunning && s.leader == s.self {
-		startCtx, cancel := context.WithCancel(ctx)
-		s.cancel = cancel
-		s.methods.start(startCtx, s.finishChan)
-		s.runnerIsRunning = true
+	if s.runnerIsRunning {
+		return
 	}
+	startCtx, cancel := context.WithCancel(ctx)
+	s.cancel = cancel
+	s.methods.start(startCtx, s.finishChan)
+	s.runnerIsRunning = true
+	s.runnerStarts++
+	s.options.metrics.RunnerStarted()
+	s.options.logger.Info("leader runner started", "starts", s.runnerStarts)
+}
+
+func (s *coreService) stopLeader() {
+	if s.cancel == nil {
+		return
+	}
+	s.options.logger.Info("stopping leader runner")
+	s.cancel()
+	s.cancel = nil
 }
 
 func (s *coreService) computeAndStartLeader(ctx context.Context) {
-	newLeader := s.state.computeLeader(
-		s.self.addr, s.getNow().Add(-s.options.expireDuration), s.lastUpdate)
+	// a node that has left is never elected
+	newLeader := computeLeader(
+		s.state, s.self.addr, !s.left, s.getNow(), s.lastUpdate, s.options.failureDetector)
 
-	// TODO only if running
-	if s.leader == s.self && newLeader != s.self {
-		s.cancel()
+	newLeaderAddr := addrOf(s.state, newLeader.addr)
+	if !s.checkBootstrapped() {
+		// the leader is not published until the bootstrap expectations have been met
+		newLeaderAddr = ""
 	}
-
-	if s.leader.addr != newLeader.addr {
+	if s.leaderAddr != newLeaderAddr {
 		for i, waiter := range s.leaderWaitList {
-			waiter <- newLeader.addr
+			notifyLeader(waiter, newLeaderAddr)
 			s.leaderWaitList[i] = nil
 		}
 		s.leaderWaitList = s.leaderWaitList[:0]
+		s.options.metrics.LeaderChanged(newLeaderAddr)
+		s.options.logger.Info("leader changed", "leader", newLeaderAddr, "previous", s.leaderAddr)
 	}
 
 	s.leader = newLeader
+	s.leaderAddr = newLeaderAddr
+	s.updateLeaderRunner(ctx)
+}
+
+func (s *coreService) aliveMembers() int {
+	count := 0
+	s.state.each(func(_ string, e Entry) {
+		if !e.OutOfSync {
+			count++
+		}
+	})
+	return count
+}
 
+// checkBootstrapped returns true once the bootstrap expectations have been met
+func (s *coreService) checkBootstrapped() bool {
+	if s.bootstrapped {
+		return true
+	}
+	if s.aliveMembers() < s.options.minClusterSize {
+		return false
+	}
+	if s.syncRounds < s.options.bootstrapSyncRounds {
+		return false
+	}
+	s.bootstrapped = true
+	return true
+}
+
+// updateLeaderRunner starts or stops the leader runner
+// depending on the current leader, the bootstrap expectations and the lease
+func (s *coreService) updateLeaderRunner(ctx context.Context) {
+	if s.left || s.leader != s.self || !s.checkBootstrapped() || !s.holdLease(s.getNow()) {
+		s.stopLeader()
+		return
+	}
 	s.startLeader(ctx)
 }
 
+func (s *coreService) handleUpdateResult(ctx context.Context, result updateResult) {
+	if s.pendingUpdates > 0 {
+		s.pendingUpdates--
+	}
+	if result.err != nil {
+		s.syncErrors[result.addr]++
+		s.forgetPeerState(result.addr)
+		s.options.metrics.SyncFailed(result.addr)
+		s.options.logger.Warn("update remote failed", "addr", result.addr, "error", result.err)
+		s.finishJoin(ctx, result.addr)
+		return
+	}
+	s.options.metrics.SyncSucceeded(result.addr)
+	s.lastSynced[result.addr] = result.sentAt
+	s.updateWithState(result.state)
+	if !result.partial {
+		s.ackPeerState(ctx, result.addr, result.state)
+	}
+	s.finishJoin(ctx, result.addr)
+	s.computeAndStartLeader(ctx)
+}
+
+// finishJoin records the first exchange with addr. Once every remote address has been tried,
+// the HLC has observed the reachable nodes, so the seniority timestamp taken from it
+// orders this node after them whatever the skew of the wall clocks
+func (s *coreService) finishJoin(ctx context.Context, addr string) {
+	if !s.joining[addr] {
+		return
+	}
+	delete(s.joining, addr)
+	if len(s.joining) > 0 {
+		return
+	}
+	s.joining = nil
+
+	// an adopted or restored seniority is kept
+	if s.self.timestamp != s.incarnation {
+		return
+	}
+	s.self.timestamp = s.options.clock.Now()
+	s.options.logger.Info("seniority taken", "timestamp", s.self.timestamp)
+	s.bumpSelfEntry()
+	s.computeAndStartLeader(ctx)
+}
+
 func (s *coreService) init(ctx context.Context) {
 	s.syncTimer.Reset(s.options.syncDuration)
 
 	s.stateTerm = 1
 	s.stateVersion = 1
-	newState := map[string]Entry{
-		s.self.addr: {
-			Term:      s.stateTerm,
-			Timestamp: s.self.timestamp,
-			Version:   s.stateVersion,
-		},
-	}
-	s.state = newState
+	s.state = stateTrie{}.putEntry(s.self.addr, s.selfEntry())
+	s.restoreSnapshot()
+	s.incarnationTerm = s.stateTerm
+	s.refreshKV()
 
+	if s.options.clock != nil && len(s.options.remoteAddresses) > 0 {
+		s.joining = map[string]bool{}
+		for _, remoteAddr := range s.options.remoteAddresses {
+			s.joining[remoteAddr] = true
+		}
+	}
 	for _, remoteAddr := range s.options.remoteAddresses {
 		s.callUpdateRemote(ctx, remoteAddr)
 	}
 }
 
-func (s *coreService) handleSyncTimerExpired(ctx context.Context) {
-	s.stateVersion++
-	newEntry := Entry{
+// restoreSnapshot seeds the state with the snapshot of the store.
+// Restored members are considered last updated at the snapshot time
+func (s *coreService) restoreSnapshot() {
+	if s.options.store == nil {
+		return
+	}
+	snapshot, ok, err := s.options.store.Load()
+	if err != nil {
+		s.options.logger.Warn("load snapshot failed", "error", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	s.restoreSelf(snapshot)
+	for addr, entry := range snapshot.State {
+		if addr == s.self.addr {
+			continue
+		}
+		s.state = s.state.putEntry(addr, entry)
+		s.lastUpdate[addr] = snapshot.SavedAt
+	}
+	s.state = s.state.putEntry(s.self.addr, s.selfEntry())
+	s.mergeCRDTs(stateTrie{})
+
+	now := s.getNow()
+	s.lastSnapshot = now
+	s.state = s.checkAndCallResetExpireTimer(now, s.state)
+	s.options.logger.Info("state restored", "members", s.state.len(), "term", s.stateTerm)
+}
+
+// restoreSelf restores the term and version of this node from snapshot
+func (s *coreService) restoreSelf(snapshot Snapshot) {
+	if snapshot.Term > s.stateTerm {
+		s.stateTerm = snapshot.Term
+	}
+	s.stateVersion = snapshot.Version + 1
+	if s.options.clock != nil {
+		s.options.clock.Update(snapshot.Version)
+		s.observeClock(snapshot.State)
+	}
+
+	previous, ok := snapshot.State[s.self.addr]
+	if !ok {
+		return
+	}
+	s.selfData = previous.Data
+	s.adoptCRDTs(previous.CRDTs)
+	for _, r := range previous.Data {
+		if r.Timestamp > s.lastWrite {
+			s.lastWrite = r.Timestamp
+		}
+	}
+	if s.options.nodeID == "" {
+		return
+	}
+	// a stable identity keeps its seniority and starts a new incarnation
+	if previous.Term > s.stateTerm {
+		s.stateTerm = previous.Term
+	}
+	s.self.timestamp = previous.Timestamp
+	s.stateTerm++
+	s.stateVersion = 1
+}
+
+// adoptPreviousSelf merges the data and the contributions of a received entry of this node
+// written by a previous incarnation, so that they survive a restart without a Store
+func (s *coreService) adoptPreviousSelf(input State) {
+	previous, ok := input[s.self.addr]
+	if !ok || !s.isPreviousIncarnation(previous) {
+		return
+	}
+	if s.hasAdopted && !entryLess(s.adopted, previous) {
+		return
+	}
+	s.adopted = previous
+	s.hasAdopted = true
+
+	s.adoptSeniority(previous)
+	s.adoptData(previous.Data)
+	s.adoptCRDTs(previous.CRDTs)
+	s.bumpSelfEntry()
+}
+
+func (s *coreService) isPreviousIncarnation(e Entry) bool {
+	return e.Timestamp != s.self.timestamp || e.Term < s.incarnationTerm
+}
+
+// adoptSeniority keeps the timestamp of an older incarnation of a node with a stable identity,
+// when its entry has not been bumped yet
+func (s *coreService) adoptSeniority(previous Entry) {
+	if s.options.nodeID == "" || s.bumped || previous.Timestamp >= s.self.timestamp {
+		return
+	}
+	s.options.logger.Info("seniority adopted", "timestamp", previous.Timestamp, "previous", s.self.timestamp)
+	s.self.timestamp = previous.Timestamp
+	if previous.Term > s.stateTerm {
+		s.stateTerm = previous.Term
+	}
+	s.stateTerm++
+	s.incarnationTerm = s.stateTerm
+}
+
+// saveSnapshot hands the snapshot to the snapshot writer, replacing the one not written yet
+func (s *coreService) saveSnapshot(now time.Time) {
+	s.lastSnapshot = now
+	snapshot := Snapshot{
+		State:   s.getState(),
+		Term:    s.stateTerm,
+		Version: s.stateVersion,
+		SavedAt: now,
+	}
+	select {
+	case <-s.snapshotChan:
+	default:
+	}
+	s.snapshotChan <- snapshot
+}
+
+// startSnapshotWriter saves the snapshots on a new goroutine, so that the core is not blocked by the Store
+func (s *coreService) startSnapshotWriter() {
+	if s.options.store == nil {
+		return
+	}
+	go func() {
+		defer close(s.snapshotDone)
+		for snapshot := range s.snapshotChan {
+			if err := s.options.store.Save(snapshot); err != nil {
+				s.options.logger.Warn("save snapshot failed", "error", err)
+			}
+		}
+	}()
+}
+
+// stopSnapshotWriter waits until the last snapshot is saved
+func (s *coreService) stopSnapshotWriter() {
+	if s.options.store == nil {
+		return
+	}
+	close(s.snapshotChan)
+	<-s.snapshotDone
+}
+
+func (s *coreService) saveSnapshotIfDue() {
+	if s.options.store == nil {
+		return
+	}
+	now := s.getNow()
+	if now.Sub(s.lastSnapshot) < s.options.snapshotInterval {
+		return
+	}
+	s.saveSnapshot(now)
+}
+
+// selfEntry returns the entry of this node for the current term and version
+func (s *coreService) selfEntry() Entry {
+	entry := Entry{
 		Term:      s.stateTerm,
 		Timestamp: s.self.timestamp,
 		Version:   s.stateVersion,
+		Data:      s.selfData,
+		CRDTs:     s.selfCRDTs,
+	}
+	if s.selfAddr != s.self.addr {
+		entry.Addr = s.selfAddr
+	}
+	return entry
+}
+
+// bumpSelfEntry increases the version of the self entry,
+// and also the term if the state contains a greater self entry
+func (s *coreService) bumpSelfEntry() {
+	s.bumped = true
+	if s.options.clock != nil {
+		s.stateVersion = s.options.clock.Now()
+	} else {
+		s.stateVersion++
 	}
-	newTerm, updated := s.state.checkUpdated(s.self.addr, newEntry)
+	newEntry := s.selfEntry()
+	newEntry.OutOfSync = s.left
+	newTerm, updated := checkUpdated(s.state, s.self.addr, newEntry)
 	if !updated {
+		s.options.logger.Info("term bumped", "previous", s.stateTerm, "term", newTerm)
 		s.stateTerm = newTerm
 		newEntry.Term = newTerm
 	}
 	s.state = s.state.putEntry(s.self.addr, newEntry)
+}
+
+func (s *coreService) handleSyncTimerExpired(ctx context.Context) {
+	roundCtx, span := s.options.tracer.StartSpan(ctx, SpanSyncRound)
+	defer span.End()
+
+	s.syncRounds++
+	s.bumpSelfEntry()
+	span.SetAttribute(AttrStateSize, s.state.len())
 
 	// TODO add test
 	if len(s.options.remoteAddresses) > 0 {
 		remoteAddr := s.options.remoteAddresses[s.nextAddrIndex]
-		s.nextAddrIndex += (s.nextAddrIndex + 1) % len(s.options.remoteAddresses)
-		s.callUpdateRemote(ctx, remoteAddr)
+		s.nextAddrIndex = (s.nextAddrIndex + 1) % len(s.options.remoteAddresses)
+		span.SetAttribute(AttrPeer, remoteAddr)
+		s.syncRemote(roundCtx, remoteAddr)
+	}
+	if s.options.probeEnabled {
+		s.probeRandomMember(ctx)
 	}
 	s.computeAndStartLeader(ctx)
+	span.SetAttribute(AttrLeader, s.leaderAddr)
+
+	s.saveSnapshotIfDue()
+}
+
+// probeMembers returns the sorted list of members that can be probed
+func (s *coreService) probeMembers() []string {
+	var members []string
+	s.state.each(func(key string, e Entry) {
+		if key == s.self.addr || e.OutOfSync {
+			return
+		}
+		members = append(members, addrOf(s.state, key))
+	})
+	sort.Strings(members)
+	return members
+}
+
+func (s *coreService) probeRandomMember(ctx context.Context) {
+	members := s.probeMembers()
+	if len(members) == 0 {
+		return
+	}
+
+	index := s.randIntn(len(members))
+	addr := members[index]
+	members[index] = members[len(members)-1]
+	members = members[:len(members)-1]
+
+	var via []string
+	for len(via) < s.options.indirectProbes && len(members) > 0 {
+		index := s.randIntn(len(members))
+		via = append(via, members[index])
+		members[index] = members[len(members)-1]
+		members = members[:len(members)-1]
+	}
+
+	s.methods.probe(ctx, addr, via, s.probeResultChan)
+}
+
+func (s *coreService) handleProbeResult(ctx context.Context, result probeResult) {
+	key, existed := keyOf(s.state, result.addr)
+	if !existed {
+		return
+	}
+	if result.ok {
+		delete(s.suspected, key)
+		return
+	}
+
+	entry := s.state.entry(key)
+	if entry.OutOfSync || entry.Suspect {
+		return
+	}
+
+	now := s.getNow()
+	s.startSuspicion(key, now)
+	entry.Suspect = true
+	s.state = s.state.putEntry(key, entry)
+	s.state = s.checkAndCallResetExpireTimer(now, s.state)
+	s.computeAndStartLeader(ctx)
 }
 
 func (s *coreService) run(ctx context.Context) {
 	select {
 	case req := <-s.updateChan:
-		s.updateWithState(req.state)
-		s.computeAndStartLeader(ctx)
-		req.respChan <- s.state
+		s.options.metrics.UpdateQueueDepth(len(s.updateChan))
+		s.handleUpdateRequests(ctx, req)
 
 	case <-s.syncTimer.Chan():
 		s.syncTimer.ResetAfterChan(s.options.syncDuration)
@@ -240,36 +915,139 @@ func (s *coreService) run(ctx context.Context) {
 		s.state = s.checkAndCallResetExpireTimer(now, s.state)
 		s.computeAndStartLeader(ctx)
 
-	case req := <-s.fetchLeaderChan:
-		if req.lastLeader != s.leader.addr {
-			req.respChan <- s.leader.addr
-			return
-		}
-		s.leaderWaitList = append(s.leaderWaitList, req.respChan)
+	case result := <-s.updateResultChan:
+		s.handleUpdateResult(ctx, result)
+
+	case result := <-s.probeResultChan:
+		s.handleProbeResult(ctx, result)
+
+	case <-s.leaseTimer.Chan():
+		s.leaseTimer.ResetAfterChan(hundredYears)
+		s.computeAndStartLeader(ctx)
+
+	case req := <-s.requestChan:
+		req.handle(ctx, s)
 
 	case <-s.finishChan:
+		s.options.logger.Debug("leader runner finished")
 		s.runnerIsRunning = false
-		s.startLeader(ctx)
+		s.updateLeaderRunner(ctx)
 
 	case <-ctx.Done():
-		s.state = s.state.putEntry(s.self.addr, Entry{
-			Term:      s.stateTerm,
-			Timestamp: s.self.timestamp,
-			Version:   s.stateVersion,
-			OutOfSync: true,
-		})
-		for _, remoteAddr := range s.options.remoteAddresses {
-			s.callUpdateRemote(context.Background(), remoteAddr)
+		s.handleShutdown()
+	}
+}
+
+func (s *coreService) handleShutdown() {
+	entry := s.selfEntry()
+	entry.OutOfSync = true
+	s.state = s.state.putEntry(s.self.addr, entry)
+	if s.options.store != nil {
+		s.saveSnapshot(s.getNow())
+	}
+	for _, remoteAddr := range s.options.remoteAddresses {
+		s.callUpdateRemote(context.Background(), remoteAddr)
+	}
+}
+
+// waitShutdown waits, after the shutdown, for the results of the pending updates
+// and for the leader runner to return
+func (s *coreService) waitShutdown() {
+	for s.pendingUpdates > 0 || s.runnerIsRunning {
+		select {
+		case result := <-s.updateResultChan:
+			s.pendingUpdates--
+			if result.err != nil {
+				s.options.logger.Warn("update remote failed", "addr", result.addr, "error", result.err)
+			}
+
+		case <-s.probeResultChan:
+
+		case <-s.finishChan:
+			s.options.logger.Debug("leader runner finished")
+			s.runnerIsRunning = false
 		}
 	}
 }
 
-func (s *coreService) getState() State {
-	return s.state
+// actionRequest runs an action on the core goroutine
+type actionRequest struct {
+	action func(s *coreService, ctx context.Context)
+	done   chan<- struct{}
+}
+
+var _ coreRequest = actionRequest{}
+
+func (req actionRequest) handle(ctx context.Context, s *coreService) {
+	req.action(s, ctx)
+	close(req.done)
+}
+
+func (s *coreService) runAction(ctx context.Context, action func(s *coreService, ctx context.Context)) error {
+	done := make(chan struct{})
+	select {
+	case s.requestChan <- actionRequest{action: action, done: done}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stopped:
+		return ErrStopped
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.stopped:
+		return ErrStopped
+	}
+}
+
+// checkStarted returns ErrNotStarted when the core has never been started
+func (s *coreService) checkStarted() error {
+	select {
+	case <-s.started:
+		return nil
+	default:
+		return ErrNotStarted
+	}
+}
+
+// stepDown makes this node the youngest member by renewing its timestamp,
+// so that the leadership moves to the next oldest member
+func (s *coreService) stepDown(ctx context.Context) {
+	s.options.logger.Info("stepping down", "leader", s.leaderAddr)
+	if s.options.clock != nil {
+		s.self.timestamp = s.options.clock.Now()
+	} else {
+		s.self.timestamp = uint64(s.getNow().UnixNano())
+	}
+	s.bumpSelfEntry()
+	s.computeAndStartLeader(ctx)
+}
+
+// leave marks this node out of sync and broadcasts it to remote addresses,
+// after leaving the node never runs the leader again
+func (s *coreService) leave(ctx context.Context) {
+	if s.left {
+		return
+	}
+	s.options.logger.Info("leaving cluster")
+	s.left = true
+	s.bumpSelfEntry()
+	s.computeAndStartLeader(ctx)
+	for _, remoteAddr := range s.options.remoteAddresses {
+		s.callUpdateRemote(ctx, remoteAddr)
+	}
 }
 
-func (s *coreService) fetchLeader(req fetchLeaderRequest) {
-	s.fetchLeaderChan <- req
+// getState returns the state as a State, materialized once per change
+func (s *coreService) getState() State {
+	if s.snapshot == nil || s.snapshotRoot != s.state.root {
+		s.snapshot = s.state.toState()
+		s.snapshotRoot = s.state.root
+	}
+	return s.snapshot
 }
 
 func (s *coreService) newLeaderWatcher() *leaderWatcher {
@@ -280,10 +1058,28 @@ func (s *coreService) newLeaderWatcher() *leaderWatcher {
 	}
 }
 
-func (w *leaderWatcher) watch(lastLeader string) <-chan string {
-	w.core.fetchLeader(fetchLeaderRequest{
-		lastLeader: lastLeader,
-		respChan:   w.ch,
-	})
-	return w.ch
+// next waits for a leader different from lastLeader
+func (w *leaderWatcher) next(ctx context.Context, lastLeader string) (string, error) {
+	// drop the leader sent after a cancelled call, the request gets the current one
+	select {
+	case <-w.ch:
+	default:
+	}
+
+	select {
+	case w.core.requestChan <- fetchLeaderRequest{lastLeader: lastLeader, respChan: w.ch}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-w.core.stopped:
+		return "", ErrStopped
+	}
+
+	select {
+	case leader := <-w.ch:
+		return leader, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-w.core.stopped:
+		return "", ErrStopped
+	}
 }
@@ -18,6 +18,12 @@ var _ callbacks = &callbacksMock{}
 //
 // 		// make and configure a mocked callbacks
 // 		mockedcallbacks := &callbacksMock{
+// 			antiEntropyFunc: func(ctx context.Context, addr string, state State, resultChan chan<- updateResult)  {
+// 				panic("mock out the antiEntropy method")
+// 			},
+// 			probeFunc: func(ctx context.Context, addr string, via []string, resultChan chan<- probeResult)  {
+// 				panic("mock out the probe method")
+// 			},
 // 			startFunc: func(ctx context.Context, finish chan<- struct{})  {
 // 				panic("mock out the start method")
 // 			},
@@ -31,6 +37,12 @@ var _ callbacks = &callbacksMock{}
 //
 // 	}
 type callbacksMock struct {
+	// antiEntropyFunc mocks the antiEntropy method.
+	antiEntropyFunc func(ctx context.Context, addr string, state State, resultChan chan<- updateResult)
+
+	// probeFunc mocks the probe method.
+	probeFunc func(ctx context.Context, addr string, via []string, resultChan chan<- probeResult)
+
 	// startFunc mocks the start method.
 	startFunc func(ctx context.Context, finish chan<- struct{})
 
@@ -39,6 +51,28 @@ type callbacksMock struct {
 
 	// calls tracks calls to the methods.
 	calls struct {
+		// antiEntropy holds details about calls to the antiEntropy method.
+		antiEntropy []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Addr is the addr argument value.
+			Addr string
+			// State is the state argument value.
+			State State
+			// ResultChan is the resultChan argument value.
+			ResultChan chan<- updateResult
+		}
+		// probe holds details about calls to the probe method.
+		probe []struct {
+			// Ctx is the ctx argument value.
+			Ctx context.Context
+			// Addr is the addr argument value.
+			Addr string
+			// Via is the via argument value.
+			Via []string
+			// ResultChan is the resultChan argument value.
+			ResultChan chan<- probeResult
+		}
 		// start holds details about calls to the start method.
 		start []struct {
 			// Ctx is the ctx argument value.
@@ -58,10 +92,98 @@ type callbacksMock struct {
 			ResultChan chan<- updateResult
 		}
 	}
+	lockantiEntropy  sync.RWMutex
+	lockprobe        sync.RWMutex
 	lockstart        sync.RWMutex
 	lockupdateRemote sync.RWMutex
 }
 
+// antiEntropy calls antiEntropyFunc.
+func (mock *callbacksMock) antiEntropy(ctx context.Context, addr string, state State, resultChan chan<- updateResult) {
+	if mock.antiEntropyFunc == nil {
+		panic("callbacksMock.antiEntropyFunc: method is nil but callbacks.antiEntropy was just called")
+	}
+	callInfo := struct {
+		Ctx        context.Context
+		Addr       string
+		State      State
+		ResultChan chan<- updateResult
+	}{
+		Ctx:        ctx,
+		Addr:       addr,
+		State:      state,
+		ResultChan: resultChan,
+	}
+	mock.lockantiEntropy.Lock()
+	mock.calls.antiEntropy = append(mock.calls.antiEntropy, callInfo)
+	mock.lockantiEntropy.Unlock()
+	mock.antiEntropyFunc(ctx, addr, state, resultChan)
+}
+
+// antiEntropyCalls gets all the calls that were made to antiEntropy.
+// Check the length with:
+//     len(mockedcallbacks.antiEntropyCalls())
+func (mock *callbacksMock) antiEntropyCalls() []struct {
+	Ctx        context.Context
+	Addr       string
+	State      State
+	ResultChan chan<- updateResult
+} {
+	var calls []struct {
+		Ctx        context.Context
+		Addr       string
+		State      State
+		ResultChan chan<- updateResult
+	}
+	mock.lockantiEntropy.RLock()
+	calls = mock.calls.antiEntropy
+	mock.lockantiEntropy.RUnlock()
+	return calls
+}
+
+// probe calls probeFunc.
+func (mock *callbacksMock) probe(ctx context.Context, addr string, via []string, resultChan chan<- probeResult) {
+	if mock.probeFunc == nil {
+		panic("callbacksMock.probeFunc: method is nil but callbacks.probe was just called")
+	}
+	callInfo := struct {
+		Ctx        context.Context
+		Addr       string
+		Via        []string
+		ResultChan chan<- probeResult
+	}{
+		Ctx:        ctx,
+		Addr:       addr,
+		Via:        via,
+		ResultChan: resultChan,
+	}
+	mock.lockprobe.Lock()
+	mock.calls.probe = append(mock.calls.probe, callInfo)
+	mock.lockprobe.Unlock()
+	mock.probeFunc(ctx, addr, via, resultChan)
+}
+
+// probeCalls gets all the calls that were made to probe.
+// Check the length with:
+//     len(mockedcallbacks.probeCalls())
+func (mock *callbacksMock) probeCalls() []struct {
+	Ctx        context.Context
+	Addr       string
+	Via        []string
+	ResultChan chan<- probeResult
+} {
+	var calls []struct {
+		Ctx        context.Context
+		Addr       string
+		Via        []string
+		ResultChan chan<- probeResult
+	}
+	mock.lockprobe.RLock()
+	calls = mock.calls.probe
+	mock.lockprobe.RUnlock()
+	return calls
+}
+
 // start calls startFunc.
 func (mock *callbacksMock) start(ctx context.Context, finish chan<- struct{}) {
 	if mock.startFunc == nil {
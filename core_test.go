@@ -18,7 +18,9 @@ func mustParse(s string) time.Time {
 func newCallbacksMock() *callbacksMock {
 	methods := &callbacksMock{}
 	methods.updateRemoteFunc = func(ctx context.Context, addr string, state State, resultChan chan<- updateResult) {}
+	methods.antiEntropyFunc = func(ctx context.Context, addr string, state State, resultChan chan<- updateResult) {}
 	methods.startFunc = func(ctx context.Context, finish chan<- struct{}) {}
+	methods.probeFunc = func(ctx context.Context, addr string, via []string, resultChan chan<- probeResult) {}
 	return methods
 }
 
@@ -1169,3 +1171,989 @@ func TestCoreService_Init__UpdateRemote(t *testing.T) {
 //		"remote-addr-2",
 //	}, updateAddrs)
 //}
+
+func newTimerMock() *TimerMock {
+	timer := &TimerMock{}
+	timer.ResetFunc = func(d time.Duration) {}
+	timer.ResetAfterChanFunc = func(d time.Duration) {}
+	timer.ChanFunc = func() <-chan time.Time { return nil }
+	return timer
+}
+
+// newTestCoreService creates the core service of self-addr with mocked timers and a fixed clock,
+// syncing with remote-addr-1 unless the options say otherwise, and keyed by the node ID of the options
+// like NewRunner does
+func newTestCoreService(methods *callbacksMock, options ...Option) *coreService {
+	opts := computeOptions(append([]Option{
+		AddRemoteAddress("remote-addr-1"),
+		WithExpireDuration(30 * time.Second),
+	}, options...)...)
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	if opts.nodeID != "" {
+		self.addr = opts.nodeID
+	}
+	if opts.clock != nil {
+		self.timestamp = opts.clock.Now()
+	}
+	s := newCoreService(methods, self, opts)
+	s.selfAddr = "self-addr"
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.leaseTimer = newTimerMock()
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:00Z") }
+	return s
+}
+
+func TestCoreService_LeaderLease__Not_Start_Without_Majority(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods,
+		AddRemoteAddress("remote-addr-2"),
+		WithExpireDuration(60*time.Second),
+		WithLeaderLease(20*time.Second, 5*time.Second),
+	)
+
+	s.init(context.Background())
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, "self-addr", s.leader.addr)
+	assert.Equal(t, 0, len(methods.startCalls()))
+}
+
+func TestCoreService_LeaderLease__Start_After_Synced_With_Majority(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	leaseTimer := newTimerMock()
+	s := newTestCoreService(methods,
+		AddRemoteAddress("remote-addr-2"),
+		WithExpireDuration(60*time.Second),
+		WithLeaderLease(20*time.Second, 5*time.Second),
+	)
+	s.leaseTimer = leaseTimer
+
+	s.init(context.Background())
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:03Z") }
+	s.updateResultChan <- updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"remote-addr-1": {
+				Term:      1,
+				Timestamp: 200,
+				Version:   1,
+			},
+		},
+		sentAt: mustParse("2021-06-05T10:20:01Z"),
+	}
+	s.run(context.Background())
+
+	assert.Equal(t, "self-addr", s.leader.addr)
+	assert.Equal(t, 1, len(methods.startCalls()))
+	assert.Equal(t, 1, len(leaseTimer.ResetCalls()))
+	// measured from the time the request was sent
+	assert.Equal(t, 18*time.Second, leaseTimer.ResetCalls()[0].D)
+}
+
+func TestCoreService_LeaderLease__Failed_Sync_Not_Counted(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods,
+		AddRemoteAddress("remote-addr-2"),
+		WithExpireDuration(60*time.Second),
+		WithLeaderLease(20*time.Second, 5*time.Second),
+	)
+
+	s.init(context.Background())
+	s.updateResultChan <- updateResult{
+		addr: "remote-addr-1",
+		err:  context.DeadlineExceeded,
+	}
+	s.run(context.Background())
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, 0, len(methods.startCalls()))
+	assert.Equal(t, map[string]time.Time{}, s.lastSynced)
+}
+
+func TestCoreService_LeaderLease__Cancel_Start_When_Lease_Expired(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	var startCtx context.Context
+	methods.startFunc = func(ctx context.Context, finish chan<- struct{}) {
+		startCtx = ctx
+	}
+
+	leaseChan := make(chan time.Time, 1)
+	leaseTimer := newTimerMock()
+	leaseTimer.ChanFunc = func() <-chan time.Time { return leaseChan }
+
+	s := newTestCoreService(methods,
+		AddRemoteAddress("remote-addr-2"),
+		WithExpireDuration(60*time.Second),
+		WithLeaderLease(20*time.Second, 5*time.Second),
+	)
+	s.leaseTimer = leaseTimer
+
+	s.init(context.Background())
+	s.updateResultChan <- updateResult{
+		addr:   "remote-addr-1",
+		state:  State{},
+		sentAt: mustParse("2021-06-05T10:20:00Z"),
+	}
+	s.run(context.Background())
+
+	assert.Equal(t, 1, len(methods.startCalls()))
+	assert.Equal(t, nil, startCtx.Err())
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:20Z") }
+	leaseChan <- time.Time{}
+	s.run(context.Background())
+
+	assert.Equal(t, []time.Duration{hundredYears}, timerResetAfterChanDurations(leaseTimer))
+	assert.Equal(t, context.Canceled, startCtx.Err())
+	assert.Equal(t, "self-addr", s.leader.addr)
+
+	// finish without lease => not restart
+	s.finishChan <- struct{}{}
+	s.run(context.Background())
+	assert.Equal(t, 1, len(methods.startCalls()))
+}
+
+func timerResetAfterChanDurations(timer *TimerMock) []time.Duration {
+	var result []time.Duration
+	for _, call := range timer.ResetAfterChanCalls() {
+		result = append(result, call.D)
+	}
+	return result
+}
+
+func TestCoreService_LeaseExpiry(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		AddRemoteAddress("remote-addr-2"),
+		WithExpireDuration(60*time.Second),
+		WithLeaderLease(20*time.Second, 5*time.Second),
+	)
+	s.init(context.Background())
+
+	now := mustParse("2021-06-05T10:20:30Z")
+
+	_, ok := s.leaseExpiry(now)
+	assert.Equal(t, false, ok)
+
+	s.lastSynced["remote-addr-1"] = mustParse("2021-06-05T10:20:05Z")
+	s.lastSynced["remote-addr-2"] = mustParse("2021-06-05T10:20:12Z")
+
+	expiry, ok := s.leaseExpiry(now)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, mustParse("2021-06-05T10:20:32Z"), expiry)
+
+	s.lastSynced["remote-addr-2"] = mustParse("2021-06-05T10:20:10Z")
+	_, ok = s.leaseExpiry(now)
+	assert.Equal(t, false, ok)
+}
+
+func TestCoreService_LeaseExpiry__Members_Left(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		AddRemoteAddress("remote-addr-2"),
+		WithExpireDuration(60*time.Second),
+		WithLeaderLease(20*time.Second, 5*time.Second),
+	)
+	s.init(context.Background())
+	s.updateWithState(State{
+		"remote-addr-3": {Term: 1, Timestamp: 300, Version: 1},
+		"remote-addr-4": {Term: 1, Timestamp: 400, Version: 1},
+	})
+
+	now := mustParse("2021-06-05T10:20:30Z")
+	s.lastSynced["remote-addr-1"] = mustParse("2021-06-05T10:20:15Z")
+
+	// two of the four other members are needed
+	_, ok := s.leaseExpiry(now)
+	assert.Equal(t, false, ok)
+
+	s.updateWithState(State{
+		"remote-addr-3": {Term: 1, Timestamp: 300, Version: 2, OutOfSync: true},
+		"remote-addr-4": {Term: 1, Timestamp: 400, Version: 2, OutOfSync: true},
+	})
+	assert.Equal(t, 3, s.clusterSize())
+
+	expiry, ok := s.leaseExpiry(now)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, mustParse("2021-06-05T10:20:35Z"), expiry)
+
+	// the syncs with a member that has left are not counted
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 2, OutOfSync: true},
+	})
+	assert.Equal(t, 2, s.clusterSize())
+
+	_, ok = s.leaseExpiry(now)
+	assert.Equal(t, false, ok)
+}
+
+func TestComputeOptions__Invalid_Lease(t *testing.T) {
+	t.Parallel()
+
+	assert.Panics(t, func() {
+		computeOptions(
+			WithExpireDuration(30*time.Second),
+			WithLeaderLease(25*time.Second, 5*time.Second),
+		)
+	})
+	assert.NotPanics(t, func() {
+		computeOptions(
+			WithExpireDuration(30*time.Second),
+			WithLeaderLease(24*time.Second, 5*time.Second),
+		)
+	})
+
+	// the detector may expire the leader earlier than the expire duration
+	assert.Panics(t, func() {
+		computeOptions(
+			WithExpireDuration(30*time.Second),
+			WithFailureDetector(NewPhiAccrualFailureDetector(PhiAccrualConfig{})),
+			WithLeaderLease(10*time.Second, 5*time.Second),
+		)
+	})
+	assert.Panics(t, func() {
+		computeOptions(
+			WithExpireDuration(30*time.Second),
+			WithFailureDetector(NewFixedFailureDetector(10*time.Second)),
+			WithLeaderLease(10*time.Second, 5*time.Second),
+		)
+	})
+}
+
+func TestCoreService_MinClusterSize__Not_Start_Before_Members_Seen(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(methods, self, computeOptions(WithMinClusterSize(2)))
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:00Z") }
+
+	s.init(context.Background())
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, "self-addr", s.leader.addr)
+	assert.Equal(t, 0, len(methods.startCalls()))
+
+	// the leader is not published before bootstrap
+	leaderChan := make(chan string, 1)
+	fetchLeaderRequest{lastLeader: "", respChan: leaderChan}.handle(context.Background(), s)
+	assert.Equal(t, 1, len(s.leaderWaitList))
+	assert.Equal(t, "", s.debugInfo().Leader)
+
+	respChan := make(chan State, 1)
+	s.updateChan <- updateRequest{
+		state: State{
+			"remote-addr-1": {
+				Term:      1,
+				Timestamp: 200,
+				Version:   1,
+			},
+		},
+		respChan: respChan,
+	}
+	s.run(context.Background())
+
+	assert.Equal(t, "self-addr", s.leader.addr)
+	assert.Equal(t, 1, len(methods.startCalls()))
+	assert.Equal(t, true, s.bootstrapped)
+	assert.Equal(t, "self-addr", <-leaderChan)
+}
+
+func TestCoreService_BootstrapSyncRounds__Not_Start_Before_Rounds(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(methods, self, computeOptions(WithBootstrapSyncRounds(2)))
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:00Z") }
+
+	s.init(context.Background())
+
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 0, len(methods.startCalls()))
+
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 1, len(methods.startCalls()))
+}
+
+func TestCoreService_Bootstrapped__Not_Checked_Again(t *testing.T) {
+	t.Parallel()
+
+	s := newCoreService(newCallbacksMock(), nodeID{addr: "self-addr"},
+		computeOptions(WithMinClusterSize(2)),
+	)
+	s.state = newStateTrie(State{
+		"self-addr":     {},
+		"remote-addr-1": {},
+	})
+	assert.Equal(t, true, s.checkBootstrapped())
+
+	s.state = newStateTrie(State{
+		"self-addr":     {},
+		"remote-addr-1": {OutOfSync: true},
+	})
+	assert.Equal(t, true, s.checkBootstrapped())
+}
+
+func TestCoreService_FailureDetector__Expire_Timer_Uses_Deadline(t *testing.T) {
+	t.Parallel()
+
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(newCallbacksMock(), self,
+		computeOptions(WithFailureDetector(NewFixedFailureDetector(12*time.Second))),
+	)
+	s.syncTimer = newTimerMock()
+	expireTimer := newTimerMock()
+	s.expireTimer = expireTimer
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:00Z") }
+
+	s.init(context.Background())
+	s.updateWithState(State{
+		"remote-addr-1": {
+			Term:      1,
+			Timestamp: 200,
+			Version:   1,
+		},
+	})
+
+	assert.Equal(t, 1, len(expireTimer.ResetCalls()))
+	assert.Equal(t, 12*time.Second, expireTimer.ResetCalls()[0].D)
+}
+
+// initProbeMembers starts s with three members to probe and one out of sync
+func initProbeMembers(s *coreService) {
+	s.randIntn = func(n int) int { return 0 }
+	s.init(context.Background())
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1},
+		"remote-addr-2": {Term: 1, Timestamp: 300, Version: 1},
+		"remote-addr-3": {Term: 1, Timestamp: 400, Version: 1},
+		"remote-addr-4": {Term: 1, Timestamp: 500, Version: 1, OutOfSync: true},
+	})
+}
+
+func TestCoreService_Probe__Call_Probe_With_Indirect_Members(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods,
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	initProbeMembers(s)
+
+	s.handleSyncTimerExpired(context.Background())
+
+	assert.Equal(t, 1, len(methods.probeCalls()))
+	assert.Equal(t, "remote-addr-1", methods.probeCalls()[0].Addr)
+	assert.Equal(t, []string{"remote-addr-3", "remote-addr-2"}, methods.probeCalls()[0].Via)
+}
+
+func TestCoreService_Probe__Failed_Becomes_Suspected_Then_Out_Of_Sync(t *testing.T) {
+	t.Parallel()
+
+	expireTimer := newTimerMock()
+	s := newTestCoreService(newCallbacksMock(),
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	s.expireTimer = expireTimer
+	initProbeMembers(s)
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:05Z") }
+	s.probeResultChan <- probeResult{addr: "remote-addr-2", ok: false}
+	s.run(context.Background())
+
+	assert.Equal(t, map[string]time.Time{
+		"remote-addr-2": mustParse("2021-06-05T10:20:05Z"),
+	}, s.suspected)
+	calls := expireTimer.ResetCalls()
+	assert.Equal(t, 10*time.Second, calls[len(calls)-1].D)
+	assert.Equal(t, false, s.state.entry("remote-addr-2").OutOfSync)
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:15Z") }
+	s.state = s.checkAndCallResetExpireTimer(s.getNow(), s.state)
+
+	assert.Equal(t, true, s.state.entry("remote-addr-2").OutOfSync)
+	assert.Equal(t, false, s.state.entry("remote-addr-1").OutOfSync)
+}
+
+func TestCoreService_Probe__Suspicion_Cleared_By_Newer_Entry(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	initProbeMembers(s)
+
+	s.probeResultChan <- probeResult{addr: "remote-addr-2", ok: false}
+	s.run(context.Background())
+	assert.Equal(t, 1, len(s.suspected))
+
+	s.updateWithState(State{
+		"remote-addr-2": {Term: 1, Timestamp: 300, Version: 2},
+	})
+	assert.Equal(t, map[string]time.Time{}, s.suspected)
+}
+
+func TestCoreService_Probe__Suspicion_Cleared_By_Success(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	initProbeMembers(s)
+
+	s.probeResultChan <- probeResult{addr: "remote-addr-2", ok: false}
+	s.run(context.Background())
+	s.probeResultChan <- probeResult{addr: "remote-addr-2", ok: true}
+	s.run(context.Background())
+
+	assert.Equal(t, map[string]time.Time{}, s.suspected)
+}
+
+func TestCoreService_Probe__Failed_Marks_Entry_Suspect(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	initProbeMembers(s)
+
+	s.probeResultChan <- probeResult{addr: "remote-addr-1", ok: false}
+	s.run(context.Background())
+
+	assert.Equal(t, Entry{
+		Term:      1,
+		Timestamp: 200,
+		Version:   1,
+		Suspect:   true,
+	}, s.state.entry("remote-addr-1"))
+
+	// suspect node is excluded from leadership
+	assert.Equal(t, "self-addr", s.leader.addr)
+}
+
+func TestCoreService_Suspect__Refute_By_Bumping_Version(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	initProbeMembers(s)
+
+	s.updateWithState(State{
+		"self-addr": {
+			Term:      1,
+			Timestamp: 100,
+			Version:   1,
+			Suspect:   true,
+		},
+	})
+
+	assert.Equal(t, Entry{
+		Term:      1,
+		Timestamp: 100,
+		Version:   2,
+	}, s.state.entry("self-addr"))
+}
+
+func TestCoreService_Suspect__Refute_With_Term_Bump(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	initProbeMembers(s)
+
+	// suspected entry from a previous incarnation with a greater version
+	s.updateWithState(State{
+		"self-addr": {
+			Term:      1,
+			Timestamp: 100,
+			Version:   8,
+			Suspect:   true,
+		},
+	})
+
+	assert.Equal(t, Entry{
+		Term:      2,
+		Timestamp: 100,
+		Version:   2,
+	}, s.state.entry("self-addr"))
+	assert.Equal(t, uint64(2), s.stateTerm)
+}
+
+func TestCoreService_Suspect__Gossiped_Suspicion_Then_Refuted(t *testing.T) {
+	t.Parallel()
+
+	expireTimer := newTimerMock()
+	s := newTestCoreService(newCallbacksMock(),
+		WithExpireDuration(60*time.Second),
+		WithProbing(2, 10*time.Second),
+	)
+	s.expireTimer = expireTimer
+	initProbeMembers(s)
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:03Z") }
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1, Suspect: true},
+	})
+
+	assert.Equal(t, map[string]time.Time{
+		"remote-addr-1": mustParse("2021-06-05T10:20:03Z"),
+	}, s.suspected)
+	calls := expireTimer.ResetCalls()
+	assert.Equal(t, 10*time.Second, calls[len(calls)-1].D)
+
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 2},
+	})
+	assert.Equal(t, map[string]time.Time{}, s.suspected)
+	assert.Equal(t, false, s.state.entry("remote-addr-1").Suspect)
+}
+
+type metricsRecorder struct {
+	noopMetrics
+	events []string
+}
+
+func (m *metricsRecorder) SyncAttempted(addr string) {
+	m.events = append(m.events, "sync-attempted:"+addr)
+}
+
+func (m *metricsRecorder) SyncSucceeded(addr string) {
+	m.events = append(m.events, "sync-succeeded:"+addr)
+}
+
+func (m *metricsRecorder) SyncFailed(addr string) {
+	m.events = append(m.events, "sync-failed:"+addr)
+}
+
+func (m *metricsRecorder) LeaderChanged(leader string) {
+	m.events = append(m.events, "leader-changed:"+leader)
+}
+
+func (m *metricsRecorder) MemberExpired(addr string) {
+	m.events = append(m.events, "member-expired:"+addr)
+}
+
+func (m *metricsRecorder) RunnerStarted() {
+	m.events = append(m.events, "runner-started")
+}
+
+func TestCoreService_Metrics(t *testing.T) {
+	t.Parallel()
+
+	metrics := &metricsRecorder{}
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(newCallbacksMock(), self,
+		computeOptions(
+			AddRemoteAddress("remote-addr-1"),
+			WithExpireDuration(30*time.Second),
+			WithMetrics(metrics),
+		),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:00Z") }
+
+	s.init(context.Background())
+	s.updateResultChan <- updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+		},
+	}
+	s.run(context.Background())
+
+	s.updateResultChan <- updateResult{
+		addr: "remote-addr-1",
+		err:  context.DeadlineExceeded,
+	}
+	s.run(context.Background())
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:30Z") }
+	s.state = s.checkAndCallResetExpireTimer(s.getNow(), s.state)
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, []string{
+		"sync-attempted:remote-addr-1",
+		"sync-succeeded:remote-addr-1",
+		"leader-changed:remote-addr-1",
+		"sync-failed:remote-addr-1",
+		"member-expired:remote-addr-1",
+		"leader-changed:self-addr",
+		"runner-started",
+	}, metrics.events)
+}
+
+type logRecord struct {
+	level         string
+	msg           string
+	keysAndValues []interface{}
+}
+
+type logRecorder struct {
+	records []logRecord
+}
+
+func (l *logRecorder) Debug(msg string, keysAndValues ...interface{}) {
+	l.records = append(l.records, logRecord{level: "debug", msg: msg, keysAndValues: keysAndValues})
+}
+
+func (l *logRecorder) Info(msg string, keysAndValues ...interface{}) {
+	l.records = append(l.records, logRecord{level: "info", msg: msg, keysAndValues: keysAndValues})
+}
+
+func (l *logRecorder) Warn(msg string, keysAndValues ...interface{}) {
+	l.records = append(l.records, logRecord{level: "warn", msg: msg, keysAndValues: keysAndValues})
+}
+
+func TestCoreService_Logger(t *testing.T) {
+	t.Parallel()
+
+	logger := &logRecorder{}
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(newCallbacksMock(), self,
+		computeOptions(
+			AddRemoteAddress("remote-addr-1"),
+			WithExpireDuration(30*time.Second),
+			WithLogger(logger),
+		),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:00Z") }
+
+	s.init(context.Background())
+	s.updateResultChan <- updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"self-addr":     {Term: 3, Timestamp: 100, Version: 8},
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+		},
+	}
+	s.run(context.Background())
+	s.handleSyncTimerExpired(context.Background())
+
+	s.updateResultChan <- updateResult{
+		addr: "remote-addr-1",
+		err:  context.DeadlineExceeded,
+	}
+	s.run(context.Background())
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:30Z") }
+	s.state = s.checkAndCallResetExpireTimer(s.getNow(), s.state)
+	s.computeAndStartLeader(context.Background())
+
+	s.finishChan <- struct{}{}
+	s.run(context.Background())
+
+	assert.Equal(t, []logRecord{
+		{
+			level:         "info",
+			msg:           "leader changed",
+			keysAndValues: []interface{}{"leader", "remote-addr-1", "previous", ""},
+		},
+		{
+			level:         "info",
+			msg:           "term bumped",
+			keysAndValues: []interface{}{"previous", uint64(1), "term", uint64(4)},
+		},
+		{
+			level:         "warn",
+			msg:           "update remote failed",
+			keysAndValues: []interface{}{"addr", "remote-addr-1", "error", context.DeadlineExceeded},
+		},
+		{
+			level:         "warn",
+			msg:           "member out of sync",
+			keysAndValues: []interface{}{"addr", "remote-addr-1", "lastUpdate", mustParse("2021-06-05T10:20:00Z")},
+		},
+		{
+			level:         "info",
+			msg:           "leader changed",
+			keysAndValues: []interface{}{"leader", "self-addr", "previous", "remote-addr-1"},
+		},
+		{
+			level:         "info",
+			msg:           "leader runner started",
+			keysAndValues: []interface{}{"starts", 1},
+		},
+		{
+			level: "debug",
+			msg:   "leader runner finished",
+		},
+		{
+			level:         "info",
+			msg:           "leader runner started",
+			keysAndValues: []interface{}{"starts", 2},
+		},
+	}, logger.records)
+}
+
+// startTwoNodes starts s with remote-addr-1, younger than self-addr
+func startTwoNodes(s *coreService) {
+	s.init(context.Background())
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1},
+	})
+	s.computeAndStartLeader(context.Background())
+}
+
+func TestCoreService_StepDown(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	var startCtx context.Context
+	methods.startFunc = func(ctx context.Context, finish chan<- struct{}) {
+		startCtx = ctx
+	}
+	s := newTestCoreService(methods)
+	startTwoNodes(s)
+
+	assert.Equal(t, "self-addr", s.leader.addr)
+	assert.Equal(t, 1, len(methods.startCalls()))
+
+	go func() {
+		s.run(context.Background())
+	}()
+	err := s.runAction(context.Background(), (*coreService).stepDown)
+	assert.Equal(t, nil, err)
+
+	newTimestamp := uint64(mustParse("2021-06-05T10:20:00Z").UnixNano())
+	assert.Equal(t, Entry{
+		Term:      1,
+		Timestamp: newTimestamp,
+		Version:   2,
+	}, s.state.entry("self-addr"))
+	assert.Equal(t, "remote-addr-1", s.leader.addr)
+	assert.Equal(t, context.Canceled, startCtx.Err())
+}
+
+func TestCoreService_LeaderWatcher__Cancelled_Next(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	startTwoNodes(s)
+	go func() {
+		for {
+			s.run(context.Background())
+		}
+	}()
+
+	watcher := s.newLeaderWatcher()
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := watcher.next(ctx, "self-addr")
+		cancel()
+		assert.Equal(t, context.DeadlineExceeded, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := s.runAction(ctx, (*coreService).stepDown)
+	assert.Equal(t, nil, err)
+
+	leader, err := watcher.next(ctx, "self-addr")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "remote-addr-1", leader)
+}
+
+func TestCoreService_Leave__Watcher(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	startTwoNodes(s)
+	go func() {
+		for {
+			s.run(context.Background())
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	watcher := s.newLeaderWatcher()
+	leader, err := watcher.next(ctx, "")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "self-addr", leader)
+
+	err = s.runAction(ctx, (*coreService).leave)
+	assert.Equal(t, nil, err)
+
+	leader, err = watcher.next(ctx, leader)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "remote-addr-1", leader)
+}
+
+func TestCoreService_Leave__Single_Node(t *testing.T) {
+	t.Parallel()
+
+	s := newCoreService(newCallbacksMock(), nodeID{timestamp: 100, addr: "self-addr"}, computeOptions())
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.init(context.Background())
+	s.computeAndStartLeader(context.Background())
+	assert.Equal(t, "self-addr", s.leaderAddr)
+
+	// no node is left to be elected
+	s.leave(context.Background())
+	assert.Equal(t, "", s.leaderAddr)
+	assert.Equal(t, []string(nil), s.debugInfo().Eligible)
+}
+
+func TestCoreService_Leave(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	var startCtx context.Context
+	methods.startFunc = func(ctx context.Context, finish chan<- struct{}) {
+		startCtx = ctx
+	}
+	s := newTestCoreService(methods)
+	startTwoNodes(s)
+
+	s.leave(context.Background())
+
+	assert.Equal(t, Entry{
+		Term:      1,
+		Timestamp: 100,
+		Version:   2,
+		OutOfSync: true,
+	}, s.state.entry("self-addr"))
+	assert.Equal(t, context.Canceled, startCtx.Err())
+	assert.Equal(t, "remote-addr-1", s.leaderAddr)
+	assert.Equal(t, []string{"remote-addr-1"}, s.debugInfo().Eligible)
+	assert.Equal(t, 2, len(methods.updateRemoteCalls()))
+	assert.Equal(t, "remote-addr-1", methods.updateRemoteCalls()[1].Addr)
+	assert.Equal(t, true, methods.updateRemoteCalls()[1].State["self-addr"].OutOfSync)
+
+	// not start again after finished
+	s.finishChan <- struct{}{}
+	s.run(context.Background())
+	assert.Equal(t, 1, len(methods.startCalls()))
+
+	// still out of sync after next sync round
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, true, s.state.entry("self-addr").OutOfSync)
+	assert.Equal(t, 1, len(methods.startCalls()))
+}
+
+func TestCoreService_Update__Returns_Replaced_Incarnation(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	startTwoNodes(s)
+
+	respChan := make(chan State, 1)
+	s.updateChan <- updateRequest{
+		state: State{
+			"remote-addr-1": {Term: 1, Timestamp: 300, Version: 1},
+		},
+		respChan: respChan,
+	}
+	s.run(context.Background())
+
+	assert.Equal(t, Entry{Term: 1, Timestamp: 300, Version: 1}, s.state.entry("remote-addr-1"))
+	assert.Equal(t, Entry{Term: 1, Timestamp: 200, Version: 1}, (<-respChan)["remote-addr-1"])
+
+	// the same incarnation is not replaced
+	s.updateChan <- updateRequest{
+		state: State{
+			"remote-addr-1": {Term: 1, Timestamp: 300, Version: 2},
+		},
+		respChan: respChan,
+	}
+	s.run(context.Background())
+	assert.Equal(t, Entry{Term: 1, Timestamp: 300, Version: 2}, (<-respChan)["remote-addr-1"])
+}
+
+func TestCoreService_WaitShutdown(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods)
+	startTwoNodes(s)
+	s.handleShutdown()
+
+	assert.Equal(t, true, s.runnerIsRunning)
+	pending := s.pendingUpdates
+	assert.Equal(t, len(methods.updateRemoteCalls()), pending)
+
+	done := make(chan struct{})
+	go func() {
+		s.waitShutdown()
+		close(done)
+	}()
+
+	for i := 0; i < pending; i++ {
+		s.updateResultChan <- updateResult{addr: "remote-addr-1", err: errNodeUnreachable}
+	}
+	select {
+	case <-done:
+		t.Fatal("waitShutdown returned before the leader runner")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	s.finishChan <- struct{}{}
+	<-done
+	assert.Equal(t, 0, s.pendingUpdates)
+	assert.Equal(t, false, s.runnerIsRunning)
+}
+
+func TestCoreService_RunAction__Context_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	startTwoNodes(s)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	s.requestChan = make(chan coreRequest)
+	err := s.runAction(ctx, (*coreService).leave)
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, false, s.left)
+}
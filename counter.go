@@ -0,0 +1,242 @@
+package crdtex
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// GCounter is a grow-only counter, each node increments its own count.
+// A Runner counts under a slot per incarnation of the node, so that the increments
+// made by a restarted node add up to the ones of its previous incarnations
+type GCounter map[string]uint64
+
+var _ CRDT = GCounter{}
+
+// NewGCounter creates an empty GCounter
+func NewGCounter() GCounter {
+	return GCounter{}
+}
+
+func (c GCounter) clone() GCounter {
+	result := make(GCounter, len(c))
+	for k, v := range c {
+		result[k] = v
+	}
+	return result
+}
+
+// Increment returns the counter with the count of node increased by delta
+func (c GCounter) Increment(node string, delta uint64) GCounter {
+	result := c.clone()
+	result[node] += delta
+	return result
+}
+
+// Value returns the sum of the counts of all nodes
+func (c GCounter) Value() uint64 {
+	var sum uint64
+	for _, v := range c {
+		sum += v
+	}
+	return sum
+}
+
+// Merge returns the per node maximum of the counts, or the counter unchanged when other is not a GCounter
+func (c GCounter) Merge(other CRDT) CRDT {
+	o, ok := other.(GCounter)
+	if !ok {
+		return c
+	}
+	result := c.clone()
+	for k, v := range o {
+		if v > result[k] {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// Delta returns the counts greater than the ones of since, or the counter unchanged when since is not a GCounter
+func (c GCounter) Delta(since CRDT) CRDT {
+	s, ok := since.(GCounter)
+	if !ok {
+		return c
+	}
+	result := GCounter{}
+	for k, v := range c {
+		if v > s[k] {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// Encode encodes the counter as JSON
+func (c GCounter) Encode() ([]byte, error) {
+	return json.Marshal(map[string]uint64(c))
+}
+
+// Decode decodes a JSON encoded GCounter
+func (c GCounter) Decode(data []byte) (CRDT, error) {
+	result := GCounter{}
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Equal compares the counts, ignoring the zero ones, it is false when other is not a GCounter
+func (c GCounter) Equal(other CRDT) bool {
+	o, ok := other.(GCounter)
+	if !ok {
+		return false
+	}
+	for k, v := range c {
+		if o[k] != v {
+			return false
+		}
+	}
+	for k, v := range o {
+		if c[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// PNCounter is a counter that can be incremented and decremented,
+// made of a GCounter of the increments and a GCounter of the decrements
+type PNCounter struct {
+	P GCounter `json:"p"`
+	N GCounter `json:"n"`
+}
+
+var _ CRDT = PNCounter{}
+
+// NewPNCounter creates an empty PNCounter
+func NewPNCounter() PNCounter {
+	return PNCounter{
+		P: GCounter{},
+		N: GCounter{},
+	}
+}
+
+// Add returns the counter with delta added by node
+func (c PNCounter) Add(node string, delta int64) PNCounter {
+	if delta >= 0 {
+		return PNCounter{P: c.P.Increment(node, uint64(delta)), N: c.N}
+	}
+	return PNCounter{P: c.P, N: c.N.Increment(node, uint64(-delta))}
+}
+
+// Value returns the sum of the increments minus the sum of the decrements
+func (c PNCounter) Value() int64 {
+	return int64(c.P.Value()) - int64(c.N.Value())
+}
+
+// Merge merges the increments and the decrements, or returns the counter unchanged when other is not a PNCounter
+func (c PNCounter) Merge(other CRDT) CRDT {
+	o, ok := other.(PNCounter)
+	if !ok {
+		return c
+	}
+	return PNCounter{
+		P: c.P.Merge(o.P).(GCounter),
+		N: c.N.Merge(o.N).(GCounter),
+	}
+}
+
+// Delta returns the increments and decrements not included in since, or the counter unchanged when since is not a PNCounter
+func (c PNCounter) Delta(since CRDT) CRDT {
+	s, ok := since.(PNCounter)
+	if !ok {
+		return c
+	}
+	return PNCounter{
+		P: c.P.Delta(s.P).(GCounter),
+		N: c.N.Delta(s.N).(GCounter),
+	}
+}
+
+// Encode encodes the counter as JSON
+func (c PNCounter) Encode() ([]byte, error) {
+	return json.Marshal(c)
+}
+
+// Decode decodes a JSON encoded PNCounter
+func (c PNCounter) Decode(data []byte) (CRDT, error) {
+	result := NewPNCounter()
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	if result.P == nil {
+		result.P = GCounter{}
+	}
+	if result.N == nil {
+		result.N = GCounter{}
+	}
+	return result, nil
+}
+
+// Equal compares the increments and the decrements, it is false when other is not a PNCounter
+func (c PNCounter) Equal(other CRDT) bool {
+	o, ok := other.(PNCounter)
+	if !ok {
+		return false
+	}
+	return c.P.Equal(o.P) && c.N.Equal(o.N)
+}
+
+// counterSlot is the key of the count of an incarnation of node
+func counterSlot(node string, incarnation uint64) string {
+	return node + "/" + strconv.FormatUint(incarnation, 10)
+}
+
+// IncrementGCounter increments by delta the count of this node in the GCounter registered with name
+func (r *Runner) IncrementGCounter(ctx context.Context, name string, delta uint64) error {
+	return r.updateCRDTOfType(ctx, name, func(node string, incarnation uint64, current CRDT) (CRDT, bool) {
+		c, ok := current.(GCounter)
+		if !ok {
+			return nil, false
+		}
+		return c.Increment(counterSlot(node, incarnation), delta), true
+	})
+}
+
+// AddPNCounter adds delta to the count of this node in the PNCounter registered with name
+func (r *Runner) AddPNCounter(ctx context.Context, name string, delta int64) error {
+	return r.updateCRDTOfType(ctx, name, func(node string, incarnation uint64, current CRDT) (CRDT, bool) {
+		c, ok := current.(PNCounter)
+		if !ok {
+			return nil, false
+		}
+		return c.Add(counterSlot(node, incarnation), delta), true
+	})
+}
+
+// GCounterValue returns the value of the GCounter registered with name
+func (r *Runner) GCounterValue(ctx context.Context, name string) (uint64, error) {
+	value, err := r.CRDT(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	c, ok := value.(GCounter)
+	if !ok {
+		return 0, ErrCRDTType
+	}
+	return c.Value(), nil
+}
+
+// PNCounterValue returns the value of the PNCounter registered with name
+func (r *Runner) PNCounterValue(ctx context.Context, name string) (int64, error) {
+	value, err := r.CRDT(ctx, name)
+	if err != nil {
+		return 0, err
+	}
+	c, ok := value.(PNCounter)
+	if !ok {
+		return 0, ErrCRDTType
+	}
+	return c.Value(), nil
+}
@@ -0,0 +1,180 @@
+package crdtex
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGCounter(t *testing.T) {
+	t.Parallel()
+
+	c := NewGCounter().Increment("node-1", 3).Increment("node-2", 2)
+	c2 := c.Increment("node-1", 4)
+
+	assert.Equal(t, uint64(5), c.Value())
+	assert.Equal(t, uint64(9), c2.Value())
+	assert.Equal(t, GCounter{"node-1": 7}, c2.Delta(c))
+
+	merged := c2.Merge(NewGCounter().Increment("node-2", 5).Increment("node-3", 1))
+	assert.Equal(t, GCounter{"node-1": 7, "node-2": 5, "node-3": 1}, merged)
+
+	assert.True(t, NewGCounter().Equal(GCounter{"node-1": 0}))
+}
+
+func TestPNCounter(t *testing.T) {
+	t.Parallel()
+
+	c := NewPNCounter().Add("node-1", 5).Add("node-2", -3).Add("node-1", -1)
+	assert.Equal(t, int64(1), c.Value())
+
+	data, err := c.Encode()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, `{"p":{"node-1":5},"n":{"node-1":1,"node-2":3}}`, string(data))
+
+	decoded, err := NewPNCounter().Decode([]byte(`{}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, NewPNCounter(), decoded)
+}
+
+func TestCounters__Other_Type(t *testing.T) {
+	t.Parallel()
+
+	g := NewGCounter().Increment("node-1", 3)
+	assert.Equal(t, g, g.Merge(NewPNCounter()))
+	assert.Equal(t, g, g.Delta(NewORSet()))
+	assert.False(t, g.Equal(NewPNCounter()))
+
+	pn := NewPNCounter().Add("node-1", -2)
+	assert.Equal(t, pn, pn.Merge(g))
+	assert.Equal(t, pn, pn.Delta(g))
+	assert.False(t, pn.Equal(g))
+}
+
+func TestCounters_CRDT_Laws(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, nil, CheckCRDTLaws(
+		NewGCounter(),
+		NewGCounter().Increment("node-1", 1),
+		NewGCounter().Increment("node-1", 3).Increment("node-2", 1),
+		NewGCounter().Increment("node-2", 5),
+		NewGCounter().Increment("node-3", 2).Increment("node-1", 2),
+	))
+
+	assert.Equal(t, nil, CheckCRDTLaws(
+		NewPNCounter(),
+		NewPNCounter().Add("node-1", 1),
+		NewPNCounter().Add("node-1", -3).Add("node-2", 1),
+		NewPNCounter().Add("node-2", 5).Add("node-2", -5),
+		NewPNCounter().Add("node-3", -2).Add("node-1", 2),
+	))
+}
+
+func TestRunner_Counters__Converged_Sum(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := []string{"node-1", "node-2", "node-3", "node-4"}
+	var runners []*Runner
+	for i, addr := range addrs {
+		r := net.newRunner(addr,
+			AddRemoteAddress(addrs[(i+1)%len(addrs)]),
+			WithSyncDuration(10*time.Millisecond),
+			WithExpireDuration(5*time.Second),
+			WithCRDT("requests", NewGCounter()),
+			WithCRDT("in-flight", NewPNCounter()),
+		)
+		runners = append(runners, r)
+		assert.Equal(t, nil, r.Start(ctx))
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range runners {
+		r := r
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				assert.Equal(t, nil, r.IncrementGCounter(ctx, "requests", 2))
+				assert.Equal(t, nil, r.AddPNCounter(ctx, "in-flight", 3))
+				assert.Equal(t, nil, r.AddPNCounter(ctx, "in-flight", -1))
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, r := range runners {
+		r := r
+		assert.Eventually(t, func() bool {
+			requests, err := r.GCounterValue(ctx, "requests")
+			if err != nil || requests != 400 {
+				return false
+			}
+			inFlight, err := r.PNCounterValue(ctx, "in-flight")
+			return err == nil && inFlight == 400
+		}, 5*time.Second, 5*time.Millisecond)
+	}
+
+	assert.Equal(t, ErrCRDTType, runners[0].IncrementGCounter(ctx, "in-flight", 1))
+	assert.Equal(t, ErrCRDTType, runners[0].AddPNCounter(ctx, "requests", 1))
+	_, err := runners[0].PNCounterValue(ctx, "requests")
+	assert.Equal(t, ErrCRDTType, err)
+	_, err = runners[0].GCounterValue(ctx, "unknown")
+	assert.Equal(t, ErrCRDTNotRegistered, err)
+}
+
+func TestRunner_Counters__Restart_Before_Adoption(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx := context.Background()
+
+	newRunner := func(addr string, remote string) *Runner {
+		r := net.newRunner(addr,
+			AddRemoteAddress(remote),
+			WithSyncDuration(10*time.Millisecond),
+			WithCRDT("requests", NewGCounter()),
+			WithCRDT("in-flight", NewPNCounter()),
+		)
+		assert.Equal(t, nil, r.Start(ctx))
+		return r
+	}
+	valuesEqual := func(r *Runner, requests uint64, inFlight int64) func() bool {
+		return func() bool {
+			g, err := r.GCounterValue(ctx, "requests")
+			if err != nil || g != requests {
+				return false
+			}
+			pn, err := r.PNCounterValue(ctx, "in-flight")
+			return err == nil && pn == inFlight
+		}
+	}
+
+	r1 := newRunner("node-1", "node-2")
+	r2 := newRunner("node-2", "node-1")
+	defer func() { _ = r2.Stop(ctx) }()
+
+	assert.Equal(t, nil, r1.IncrementGCounter(ctx, "requests", 100))
+	assert.Equal(t, nil, r1.AddPNCounter(ctx, "in-flight", 10))
+	assert.Eventually(t, valuesEqual(r2, 100, 10), 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, nil, r1.Stop(ctx))
+
+	// the restarted node counts before adopting the counts of its previous incarnation
+	net.setDown("node-1", true)
+	restarted := newRunner("node-1", "node-2")
+	defer func() { _ = restarted.Stop(ctx) }()
+
+	assert.Equal(t, nil, restarted.IncrementGCounter(ctx, "requests", 5))
+	assert.Equal(t, nil, restarted.AddPNCounter(ctx, "in-flight", -3))
+	assert.Equal(t, true, valuesEqual(restarted, 5, -3)())
+
+	net.setDown("node-1", false)
+	assert.Eventually(t, valuesEqual(restarted, 105, 7), 5*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, valuesEqual(r2, 105, 7), 5*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,319 @@
+package crdtex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// CRDT is a state based conflict-free replicated data type.
+// Values are immutable: Merge and Delta return new values
+type CRDT interface {
+	// Merge returns the join of the CRDT and other, which has the same type
+	Merge(other CRDT) CRDT
+
+	// Delta returns the smallest part of the CRDT that is not included in since,
+	// such that since.Merge(delta) equals the CRDT
+	Delta(since CRDT) CRDT
+
+	// Encode returns the binary representation of the CRDT
+	Encode() ([]byte, error)
+
+	// Decode returns the CRDT of the same type as the receiver encoded in data
+	Decode(data []byte) (CRDT, error)
+
+	// Equal reports whether the CRDT and other are the same value
+	Equal(other CRDT) bool
+}
+
+// ErrCRDTNotRegistered is returned when a CRDT name is not registered on the Runner
+var ErrCRDTNotRegistered = errors.New("crdtex: crdt is not registered")
+
+// ErrCRDTType is returned when a registered CRDT does not have the expected type
+var ErrCRDTType = errors.New("crdtex: crdt has another type")
+
+// CheckCRDTLaws checks that merging the samples is commutative, associative and idempotent,
+// and that Delta and Encode / Decode are consistent with Merge
+func CheckCRDTLaws(samples ...CRDT) error {
+	for i, a := range samples {
+		if !a.Merge(a).Equal(a) {
+			return fmt.Errorf("crdtex: merge of sample %d is not idempotent", i)
+		}
+		if err := checkEncoding(a); err != nil {
+			return fmt.Errorf("crdtex: sample %d: %w", i, err)
+		}
+
+		for j, b := range samples {
+			if !a.Merge(b).Equal(b.Merge(a)) {
+				return fmt.Errorf("crdtex: merge of samples %d and %d is not commutative", i, j)
+			}
+			if !b.Merge(a.Delta(b)).Equal(b.Merge(a)) {
+				return fmt.Errorf("crdtex: delta of sample %d since sample %d is not consistent with merge", i, j)
+			}
+
+			for k, c := range samples {
+				if !a.Merge(b).Merge(c).Equal(a.Merge(b.Merge(c))) {
+					return fmt.Errorf("crdtex: merge of samples %d, %d and %d is not associative", i, j, k)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func checkEncoding(c CRDT) error {
+	data, err := c.Encode()
+	if err != nil {
+		return err
+	}
+	decoded, err := c.Decode(data)
+	if err != nil {
+		return err
+	}
+	if !decoded.Equal(c) {
+		return errors.New("decoded value is not equal to the encoded one")
+	}
+	return nil
+}
+
+// Compacter is an optional interface of the CRDTs whose contributions can shrink.
+// Compact is called on the contribution of the node self when the contributions of the members changed,
+// and returns the contribution without the parts that are no longer needed
+type Compacter interface {
+	Compact(self string, contributions map[string]CRDT) CRDT
+}
+
+// crdtReplica is a registered CRDT, its value is the join of the contributions of all members
+type crdtReplica struct {
+	empty         CRDT
+	value         CRDT
+	contribution  CRDT
+	contributions map[string]CRDT // of the other members
+}
+
+func newCRDTReplicas(registered map[string]CRDT) map[string]*crdtReplica {
+	replicas := map[string]*crdtReplica{}
+	for name, empty := range registered {
+		replicas[name] = &crdtReplica{
+			empty:         empty,
+			value:         empty,
+			contribution:  empty,
+			contributions: map[string]CRDT{},
+		}
+	}
+	return replicas
+}
+
+func (r *crdtReplica) recompute() {
+	value := r.empty.Merge(r.contribution)
+	for _, c := range r.contributions {
+		value = value.Merge(c)
+	}
+	r.value = value
+}
+
+// mergeCRDTs decodes the contributions of the entries changed since oldState and recomputes the values
+func (s *coreService) mergeCRDTs(oldState stateTrie) {
+	if len(s.crdts) == 0 {
+		return
+	}
+
+	changed := false
+	s.state.eachChangedSince(oldState, func(key string, e Entry) {
+		if len(e.CRDTs) == 0 {
+			return
+		}
+		old, existed := oldState.get(key)
+		if existed && entryEqual(old, e) {
+			return
+		}
+		if key == s.self.addr {
+			// adopted by adoptPreviousSelf
+			return
+		}
+		changed = true
+		// a new incarnation starts from an empty contribution until it adopts the previous one
+		s.decodeContributions(key, e.CRDTs, existed && old.Timestamp != e.Timestamp)
+	})
+	if !changed {
+		return
+	}
+
+	s.compactCRDTs()
+	for _, replica := range s.crdts {
+		replica.recompute()
+	}
+}
+
+// decodeContributions replaces the contributions of key, or merges them into the previous ones
+func (s *coreService) decodeContributions(key string, payloads map[string][]byte, merge bool) {
+	for name, data := range payloads {
+		replica, ok := s.crdts[name]
+		if !ok {
+			continue
+		}
+		c, err := replica.empty.Decode(data)
+		if err != nil {
+			s.options.logger.Warn("decode crdt failed", "name", name, "addr", addrOf(s.state, key), "error", err)
+			continue
+		}
+		if previous, ok := replica.contributions[key]; ok && merge {
+			c = previous.Merge(c)
+		}
+		replica.contributions[key] = c
+	}
+}
+
+// compactCRDTs compacts the contributions of this node and gossips them if they changed
+func (s *coreService) compactCRDTs() {
+	changed := false
+	for _, replica := range s.crdts {
+		compacter, ok := replica.contribution.(Compacter)
+		if !ok {
+			continue
+		}
+
+		all := make(map[string]CRDT, len(replica.contributions)+1)
+		for key, c := range replica.contributions {
+			all[key] = c
+		}
+		all[s.self.addr] = replica.contribution
+
+		compacted := compacter.Compact(s.self.addr, all)
+		if compacted.Equal(replica.contribution) {
+			continue
+		}
+		replica.contribution = compacted
+		changed = true
+	}
+
+	if changed && s.publishContributions() {
+		s.bumpSelfEntry()
+	}
+}
+
+// encodeContributions returns the payloads of the contributions of this node
+func (s *coreService) encodeContributions() (map[string][]byte, error) {
+	payloads := map[string][]byte{}
+	for name, replica := range s.crdts {
+		data, err := replica.contribution.Encode()
+		if err != nil {
+			return nil, err
+		}
+		payloads[name] = data
+	}
+	return payloads, nil
+}
+
+func (s *coreService) publishContributions() bool {
+	payloads, err := s.encodeContributions()
+	if err != nil {
+		s.options.logger.Warn("encode crdt failed", "error", err)
+		return false
+	}
+	s.selfCRDTs = payloads
+	return true
+}
+
+// updateCRDT applies fn to the value of the CRDT name,
+// and adds the resulting delta to the contribution of this node
+func (s *coreService) updateCRDT(name string, fn func(current CRDT) CRDT) error {
+	replica, ok := s.crdts[name]
+	if !ok {
+		return ErrCRDTNotRegistered
+	}
+
+	updated := replica.value.Merge(fn(replica.value))
+	if updated.Equal(replica.value) {
+		return nil
+	}
+
+	previous := replica.contribution
+	replica.contribution = previous.Merge(updated.Delta(replica.value))
+	payloads, err := s.encodeContributions()
+	if err != nil {
+		replica.contribution = previous
+		return err
+	}
+
+	replica.recompute()
+	s.selfCRDTs = payloads
+	s.bumpSelfEntry()
+	return nil
+}
+
+// adoptCRDTs merges the contributions of one of the previous entries of this node
+func (s *coreService) adoptCRDTs(payloads map[string][]byte) {
+	if len(payloads) == 0 {
+		return
+	}
+	for name, data := range payloads {
+		replica, ok := s.crdts[name]
+		if !ok {
+			continue
+		}
+		c, err := replica.empty.Decode(data)
+		if err != nil {
+			s.options.logger.Warn("decode crdt failed", "name", name, "error", err)
+			continue
+		}
+		replica.contribution = replica.contribution.Merge(c)
+		replica.recompute()
+	}
+	s.publishContributions()
+}
+
+// UpdateCRDT applies fn to the current value of the CRDT registered with name.
+// The value returned by fn is merged into the local value and gossiped to the other nodes
+func (r *Runner) UpdateCRDT(ctx context.Context, name string, fn func(current CRDT) CRDT) error {
+	var updateErr error
+	err := r.runAction(ctx, func(s *coreService, _ context.Context) {
+		updateErr = s.updateCRDT(name, fn)
+	})
+	if err != nil {
+		return err
+	}
+	return updateErr
+}
+
+// CRDT returns the current value of the CRDT registered with name
+func (r *Runner) CRDT(ctx context.Context, name string) (CRDT, error) {
+	var value CRDT
+	err := r.runAction(ctx, func(s *coreService, _ context.Context) {
+		if replica, ok := s.crdts[name]; ok {
+			value = replica.value
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if value == nil {
+		return nil, ErrCRDTNotRegistered
+	}
+	return value, nil
+}
+
+// updateCRDTOfType updates the CRDT name with fn, which returns false if the CRDT has another type
+func (r *Runner) updateCRDTOfType(
+	ctx context.Context, name string, fn func(node string, incarnation uint64, current CRDT) (CRDT, bool),
+) error {
+	var updateErr error
+	typeMatched := true
+	err := r.runAction(ctx, func(s *coreService, _ context.Context) {
+		updateErr = s.updateCRDT(name, func(current CRDT) CRDT {
+			updated, ok := fn(s.self.addr, s.incarnation, current)
+			if !ok {
+				typeMatched = false
+				return current
+			}
+			return updated
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if !typeMatched {
+		return ErrCRDTType
+	}
+	return updateErr
+}
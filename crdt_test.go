@@ -0,0 +1,317 @@
+package crdtex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"time"
+)
+
+// gset is a grow-only set used to test the CRDT plug-in
+type gset map[string]struct{}
+
+var _ CRDT = gset{}
+
+func newGSet(values ...string) gset {
+	s := gset{}
+	for _, v := range values {
+		s[v] = struct{}{}
+	}
+	return s
+}
+
+func (s gset) Merge(other CRDT) CRDT {
+	result := gset{}
+	for v := range s {
+		result[v] = struct{}{}
+	}
+	for v := range other.(gset) {
+		result[v] = struct{}{}
+	}
+	return result
+}
+
+func (s gset) Delta(since CRDT) CRDT {
+	result := gset{}
+	for v := range s {
+		if _, ok := since.(gset)[v]; !ok {
+			result[v] = struct{}{}
+		}
+	}
+	return result
+}
+
+func (s gset) values() []string {
+	var values []string
+	for v := range s {
+		values = append(values, v)
+	}
+	sort.Strings(values)
+	return values
+}
+
+func (s gset) Encode() ([]byte, error) {
+	return json.Marshal(s.values())
+}
+
+func (s gset) Decode(data []byte) (CRDT, error) {
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return newGSet(values...), nil
+}
+
+func (s gset) Equal(other CRDT) bool {
+	o := other.(gset)
+	if len(s) != len(o) {
+		return false
+	}
+	for v := range s {
+		if _, ok := o[v]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// lastValue keeps the value of the right hand side of Merge, which is not commutative
+type lastValue string
+
+func (v lastValue) Merge(other CRDT) CRDT            { return other }
+func (v lastValue) Delta(CRDT) CRDT                  { return v }
+func (v lastValue) Encode() ([]byte, error)          { return []byte(v), nil }
+func (v lastValue) Decode(data []byte) (CRDT, error) { return lastValue(data), nil }
+func (v lastValue) Equal(other CRDT) bool            { return v == other.(lastValue) }
+
+func TestCheckCRDTLaws(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, nil, CheckCRDTLaws(
+		newGSet(), newGSet("a"), newGSet("a", "b"), newGSet("c"), newGSet("b", "c", "d"),
+	))
+
+	err := CheckCRDTLaws(lastValue("a"), lastValue("b"))
+	assert.Equal(t, errors.New("crdtex: merge of samples 0 and 1 is not commutative"), err)
+}
+
+func addToSet(values ...string) func(current CRDT) CRDT {
+	return func(current CRDT) CRDT {
+		return current.Merge(newGSet(values...))
+	}
+}
+
+func TestCoreService_CRDT__Update_And_Merge(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithCRDT("set", newGSet()),
+		WithLogger(&logRecorder{}),
+	)
+	s.init(context.Background())
+
+	assert.Equal(t, nil, s.updateCRDT("set", addToSet("a", "b")))
+	assert.Equal(t, Entry{
+		Term: 1, Timestamp: 100, Version: 2,
+		CRDTs: map[string][]byte{"set": []byte(`["a","b"]`)},
+	}, s.state.entry("self-addr"))
+
+	// not changed
+	assert.Equal(t, nil, s.updateCRDT("set", addToSet("a")))
+	assert.Equal(t, uint64(2), s.state.entry("self-addr").Version)
+
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1, CRDTs: map[string][]byte{
+			"set":     []byte(`["b","c"]`),
+			"unknown": []byte(`invalid`),
+		}},
+	})
+	assert.Equal(t, newGSet("a", "b", "c"), s.crdts["set"].value)
+
+	// only the delta is added to the contribution of this node
+	assert.Equal(t, nil, s.updateCRDT("set", addToSet("d")))
+	assert.Equal(t, newGSet("a", "b", "d"), s.crdts["set"].contribution)
+	assert.Equal(t, []byte(`["a","b","d"]`), s.state.entry("self-addr").CRDTs["set"])
+
+	assert.Equal(t, ErrCRDTNotRegistered, s.updateCRDT("unknown", addToSet("a")))
+}
+
+func TestCoreService_CRDT__Decode_Error(t *testing.T) {
+	t.Parallel()
+
+	logger := &logRecorder{}
+	s := newTestCoreService(newCallbacksMock(), WithCRDT("set", newGSet()), WithLogger(logger))
+	s.init(context.Background())
+
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1, CRDTs: map[string][]byte{
+			"set": []byte(`invalid`),
+		}},
+	})
+
+	assert.Equal(t, newGSet(), s.crdts["set"].value)
+	assert.Equal(t, 1, len(logger.records))
+	assert.Equal(t, "decode crdt failed", logger.records[0].msg)
+}
+
+func TestCoreService_CRDT__Adopt_Previous_Incarnation(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithCRDT("set", newGSet()),
+		WithLogger(&logRecorder{}),
+	)
+	s.init(context.Background())
+
+	// the entry of this node before a restart is still known by a remote node
+	s.updateWithState(State{
+		"self-addr": {Term: 3, Timestamp: 50, Version: 10, CRDTs: map[string][]byte{
+			"set": []byte(`["old"]`),
+		}},
+	})
+	assert.Equal(t, newGSet("old"), s.crdts["set"].contribution)
+	assert.Equal(t, Entry{
+		Term: 3, Timestamp: 100, Version: 2,
+		CRDTs: map[string][]byte{"set": []byte(`["old"]`)},
+	}, s.state.entry("self-addr"))
+}
+
+func TestCoreService_CRDT__Adopt_Older_Previous_Incarnation(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithCRDT("set", newGSet()),
+		WithLogger(&logRecorder{}),
+	)
+	s.init(context.Background())
+
+	// a restart keeps the term, the entry of the previous incarnation is older
+	s.updateWithState(State{
+		"self-addr": {Term: 1, Timestamp: 50, Version: 10, CRDTs: map[string][]byte{
+			"set": []byte(`["old"]`),
+		}},
+	})
+	assert.Equal(t, newGSet("old"), s.crdts["set"].value)
+	assert.Equal(t, Entry{
+		Term: 1, Timestamp: 100, Version: 2,
+		CRDTs: map[string][]byte{"set": []byte(`["old"]`)},
+	}, s.state.entry("self-addr"))
+
+	// adopted only once
+	s.updateWithState(State{
+		"self-addr": {Term: 1, Timestamp: 50, Version: 9},
+	})
+	assert.Equal(t, uint64(2), s.state.entry("self-addr").Version)
+}
+
+func TestCoreService_CRDT__Merge_New_Incarnation(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(),
+		WithCRDT("set", newGSet()),
+		WithLogger(&logRecorder{}),
+	)
+	s.init(context.Background())
+
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1, CRDTs: map[string][]byte{
+			"set": []byte(`["a"]`),
+		}},
+	})
+	assert.Equal(t, newGSet("a"), s.crdts["set"].value)
+
+	// the remote node restarted without its previous contribution
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 300, Version: 1, CRDTs: map[string][]byte{
+			"set": []byte(`["b"]`),
+		}},
+	})
+	assert.Equal(t, newGSet("a", "b"), s.crdts["set"].value)
+
+	// a contribution of the same incarnation replaces the previous one
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 300, Version: 2, CRDTs: map[string][]byte{
+			"set": []byte(`["c"]`),
+		}},
+	})
+	assert.Equal(t, newGSet("c"), s.crdts["set"].value)
+}
+
+func TestRunner_CRDT__Converge(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := []string{"node-1", "node-2", "node-3"}
+	var runners []*Runner
+	for i, addr := range addrs {
+		r := net.newRunner(addr,
+			AddRemoteAddress(addrs[(i+1)%len(addrs)]),
+			WithSyncDuration(10*time.Millisecond),
+			WithExpireDuration(5*time.Second),
+			WithCRDT("set", newGSet()),
+		)
+		runners = append(runners, r)
+		assert.Equal(t, nil, r.Start(ctx))
+	}
+
+	for i, r := range runners {
+		assert.Equal(t, nil, r.UpdateCRDT(ctx, "set", addToSet(addrs[i])))
+	}
+
+	for _, r := range runners {
+		r := r
+		assert.Eventually(t, func() bool {
+			value, err := r.CRDT(ctx, "set")
+			return err == nil && value.Equal(newGSet(addrs...))
+		}, 5*time.Second, 5*time.Millisecond)
+	}
+
+	_, err := runners[0].CRDT(ctx, "unknown")
+	assert.Equal(t, ErrCRDTNotRegistered, err)
+}
+
+func TestRunner_CRDT__Restart_Without_Store(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx := context.Background()
+
+	newRunner := func(addr string, remote string) *Runner {
+		r := net.newRunner(addr,
+			AddRemoteAddress(remote),
+			WithSyncDuration(10*time.Millisecond),
+			WithCRDT("count", NewGCounter()),
+		)
+		assert.Equal(t, nil, r.Start(ctx))
+		return r
+	}
+	counterEquals := func(r *Runner, expected uint64) func() bool {
+		return func() bool {
+			value, err := r.GCounterValue(ctx, "count")
+			return err == nil && value == expected
+		}
+	}
+
+	r1 := newRunner("node-1", "node-2")
+	r2 := newRunner("node-2", "node-1")
+	defer func() { _ = r2.Stop(ctx) }()
+
+	assert.Equal(t, nil, r1.IncrementGCounter(ctx, "count", 5))
+	assert.Eventually(t, counterEquals(r2, 5), 5*time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, nil, r1.Stop(ctx))
+	restarted := newRunner("node-1", "node-2")
+	defer func() { _ = restarted.Stop(ctx) }()
+
+	assert.Eventually(t, counterEquals(restarted, 5), 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, nil, restarted.IncrementGCounter(ctx, "count", 1))
+
+	assert.Eventually(t, counterEquals(r2, 6), 5*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, counterEquals(restarted, 6), 5*time.Second, 5*time.Millisecond)
+}
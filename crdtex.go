@@ -2,7 +2,9 @@ package crdtex
 
 import (
 	"context"
+	"errors"
 	"sort"
+	"sync"
 	"time"
 )
 
@@ -11,14 +13,85 @@ type Entry struct {
 	Term      uint64
 	Timestamp uint64
 	Version   uint64
+	Suspect   bool
 	OutOfSync bool
+
+	// Addr is the network address of the node, empty when it is the key of the entry
+	Addr string `json:",omitempty"`
+
+	// Data contains the replicated keys written by the node
+	Data map[string]Register `json:",omitempty"`
+
+	// CRDTs contains the encoded contributions of the node to the registered CRDTs
+	CRDTs map[string][]byte `json:",omitempty"`
+}
+
+// entryEqual compares entries without their data, which only changes with the version
+func entryEqual(a, b Entry) bool {
+	return a.Term == b.Term && a.Timestamp == b.Timestamp && a.Version == b.Version &&
+		a.Suspect == b.Suspect && a.OutOfSync == b.OutOfSync && a.Addr == b.Addr
 }
 
 // State ...
+// A State is never modified once shared, a new State is created on every change
 type State map[string]Entry
 
-// TODO Start run on a goroutine
-// TODO Async Update Remote, on a goroutine
+// entryReader reads the entries of a State or of the stateTrie of the core
+type entryReader interface {
+	get(key string) (Entry, bool)
+	each(fn func(key string, e Entry))
+	// find calls fn with the entries until it returns true
+	find(fn func(key string, e Entry) bool) bool
+}
+
+func (s State) get(key string) (Entry, bool) {
+	e, ok := s[key]
+	return e, ok
+}
+
+func (s State) each(fn func(key string, e Entry)) {
+	for k, e := range s {
+		fn(k, e)
+	}
+}
+
+func (s State) find(fn func(key string, e Entry) bool) bool {
+	for k, e := range s {
+		if fn(k, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// addrOf returns the network address of the node with key
+func addrOf(s entryReader, key string) string {
+	if e, _ := s.get(key); e.Addr != "" {
+		return e.Addr
+	}
+	return key
+}
+
+// keyOf returns the key of the node with the network address addr.
+// An entry in sync is the current node at addr, otherwise the newest of the entries left at addr is returned
+func keyOf(s entryReader, addr string) (string, bool) {
+	if e, ok := s.get(addr); ok && e.Addr == "" && !e.OutOfSync {
+		return addr, true
+	}
+	found := ""
+	var foundEntry Entry
+	s.find(func(key string, e Entry) bool {
+		if e.Addr != addr && (key != addr || e.Addr != "") {
+			return false
+		}
+		if found == "" || !e.OutOfSync || e.Timestamp > foundEntry.Timestamp {
+			found = key
+			foundEntry = e
+		}
+		return !e.OutOfSync
+	})
+	return found, found != ""
+}
 
 // Interface ...
 type Interface interface {
@@ -26,6 +99,20 @@ type Interface interface {
 	UpdateRemote(ctx context.Context, addr string, state State) (State, error)
 }
 
+// Prober is an optional extension of Interface used when probing is enabled
+type Prober interface {
+	// Ping checks directly that addr is alive
+	Ping(ctx context.Context, addr string) error
+	// PingIndirect asks the node at via to ping addr
+	PingIndirect(ctx context.Context, via string, addr string) error
+}
+
+// AntiEntropy is an optional extension of Interface used when anti-entropy is enabled
+type AntiEntropy interface {
+	// AntiEntropy sends req to addr, which handles it with Runner.AntiEntropy
+	AntiEntropy(ctx context.Context, addr string, req AntiEntropyRequest) (AntiEntropyResponse, error)
+}
+
 //go:generate moq -out crdtex_mocks_test.go . Timer
 
 // Timer for timer
@@ -44,52 +131,379 @@ type LeaderWatcher struct {
 // Runner ...
 type Runner struct {
 	core *coreService
+
+	mut      sync.Mutex
+	cancel   func()
+	stopping bool
+	err      error
+}
+
+type interfaceCallbacks struct {
+	iface             Interface
+	callRemoteTimeout time.Duration
+	tracer            Tracer
+}
+
+var _ callbacks = interfaceCallbacks{}
+
+// start runs Interface.Start on a goroutine
+func (c interfaceCallbacks) start(ctx context.Context, finish chan<- struct{}) {
+	go func() {
+		c.iface.Start(ctx)
+		finish <- struct{}{}
+	}()
+}
+
+// updateRemote runs Interface.UpdateRemote on a goroutine
+func (c interfaceCallbacks) updateRemote(
+	ctx context.Context, addr string, state State, resultChan chan<- updateResult,
+) {
+	go func() {
+		spanCtx, span := c.startCallSpan(ctx, SpanUpdateRemote, addr, state)
+
+		sentAt := time.Now()
+		callCtx, cancel := context.WithTimeout(spanCtx, c.callRemoteTimeout)
+		newState, err := c.iface.UpdateRemote(callCtx, addr, state)
+		cancel()
+
+		endCallSpan(span, newState, err)
+
+		resultChan <- updateResult{
+			addr:   addr,
+			state:  newState,
+			err:    err,
+			sentAt: sentAt,
+		}
+	}()
+}
+
+// startCallSpan starts the span of a call sending state to addr
+func (c interfaceCallbacks) startCallSpan(
+	ctx context.Context, name string, addr string, state State,
+) (context.Context, Span) {
+	spanCtx, span := c.tracer.StartSpan(ctx, name)
+	span.SetAttribute(AttrPeer, addr)
+	span.SetAttribute(AttrStateSize, len(state))
+	return spanCtx, span
+}
+
+// endCallSpan records the outcome of a call and ends its span
+func endCallSpan(span Span, response State, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetAttribute(AttrOutcome, "error")
+	} else {
+		span.SetAttribute(AttrResponseStateSize, len(response))
+		span.SetAttribute(AttrOutcome, "ok")
+	}
+	span.End()
+}
+
+// probe runs a direct ping to addr on a goroutine,
+// and when it fails, indirect pings through via
+func (c interfaceCallbacks) probe(
+	ctx context.Context, addr string, via []string, resultChan chan<- probeResult,
+) {
+	prober := c.iface.(Prober)
+	go func() {
+		callCtx, cancel := context.WithTimeout(ctx, c.callRemoteTimeout)
+		err := prober.Ping(callCtx, addr)
+		cancel()
+		if err == nil {
+			resultChan <- probeResult{addr: addr, ok: true}
+			return
+		}
+
+		// the direct ping may have used the whole timeout
+		indirectCtx, cancel := context.WithTimeout(ctx, c.callRemoteTimeout)
+		defer cancel()
+
+		indirectChan := make(chan error, len(via))
+		for _, viaAddr := range via {
+			viaAddr := viaAddr
+			go func() {
+				indirectChan <- prober.PingIndirect(indirectCtx, viaAddr, addr)
+			}()
+		}
+
+		ok := false
+		for range via {
+			if <-indirectChan == nil {
+				ok = true
+				break
+			}
+		}
+		resultChan <- probeResult{addr: addr, ok: ok}
+	}()
+}
+
+// antiEntropy runs an anti-entropy exchange with addr on a goroutine
+func (c interfaceCallbacks) antiEntropy(
+	ctx context.Context, addr string, state State, resultChan chan<- updateResult,
+) {
+	ae := c.iface.(AntiEntropy)
+	go func() {
+		spanCtx, span := c.startCallSpan(ctx, SpanAntiEntropy, addr, state)
+
+		sentAt := time.Now()
+		callCtx, cancel := context.WithTimeout(spanCtx, c.callRemoteTimeout)
+		received, err := exchangeAntiEntropy(callCtx, ae, addr, state)
+		cancel()
+
+		endCallSpan(span, received, err)
+
+		resultChan <- updateResult{
+			addr:    addr,
+			state:   received,
+			err:     err,
+			sentAt:  sentAt,
+			partial: true,
+		}
+	}()
 }
 
 // NewRunner creates a Runner
-func NewRunner(_ Interface, selfAddr string, options ...Option) *Runner {
-	timestamp := time.Now().UnixNano()
-	// TODO nil
+func NewRunner(iface Interface, selfAddr string, options ...Option) *Runner {
+	opts := computeOptions(options...)
+
+	key := selfAddr
+	if opts.nodeID != "" {
+		key = opts.nodeID
+	}
+	timestamp := uint64(time.Now().UnixNano())
+	if opts.clock != nil {
+		timestamp = opts.clock.Now()
+	}
 	self := nodeID{
-		timestamp: uint64(timestamp),
-		addr:      selfAddr,
+		timestamp: timestamp,
+		addr:      key,
+	}
+	if _, ok := iface.(Prober); opts.probeEnabled && !ok {
+		panic("crdtex: probing is enabled but Interface does not implement Prober")
+	}
+	if _, ok := iface.(AntiEntropy); opts.antiEntropyRounds > 0 && !ok {
+		panic("crdtex: anti-entropy is enabled but Interface does not implement AntiEntropy")
 	}
-	core := newCoreService(nil, self, computeOptions(options...))
+	methods := interfaceCallbacks{
+		iface:             iface,
+		callRemoteTimeout: opts.callRemoteTimeout,
+		tracer:            opts.tracer,
+	}
+	core := newCoreService(methods, self, opts)
+	core.selfAddr = selfAddr
 	return &Runner{
 		core: core,
 	}
 }
 
-// Run ...
+// Errors returned by the methods of Runner and of its watchers.
+// When ctx is done, they return ctx.Err(): context.Canceled or context.DeadlineExceeded
+var (
+	// ErrOverloaded is returned with WithFailFastUpdates when the queue of updates of the Runner is full
+	ErrOverloaded = errors.New("crdtex: runner is overloaded")
+
+	// ErrNotStarted is returned immediately when neither Run nor Start has been called
+	ErrNotStarted = errors.New("crdtex: runner is not started")
+
+	// ErrAlreadyStarted is returned by Start when Run or Start has already been called
+	ErrAlreadyStarted = errors.New("crdtex: runner is already started")
+
+	// ErrStopped is returned when the Runner is no longer running
+	ErrStopped = errors.New("crdtex: runner is stopped")
+)
+
+// Run runs the Runner until ctx is cancelled or Stop is called.
+// It returns after the shutdown is broadcast to the remote addresses and the leader Start returned,
+// and immediately when the Runner is already started.
+// The methods called before Run is entered return ErrNotStarted, Start returns once they can be called
 func (r *Runner) Run(ctx context.Context) {
-	r.core.init(ctx)
-	if ctx.Err() != nil {
+	runCtx, err := r.begin(ctx)
+	if err != nil {
 		return
 	}
+	r.run(runCtx, ctx.Err)
+}
+
+// Start runs the Runner on a new goroutine, see Run
+func (r *Runner) Start(ctx context.Context) error {
+	runCtx, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	go r.run(runCtx, ctx.Err)
+	return nil
+}
+
+func (r *Runner) begin(ctx context.Context) (context.Context, error) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	if r.cancel != nil {
+		return nil, ErrAlreadyStarted
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	r.cancel = cancel
+	close(r.core.started)
+	return runCtx, nil
+}
+
+// run runs the core until ctx is cancelled, parentErr returns the error of the context passed to Run or Start
+func (r *Runner) run(ctx context.Context, parentErr func() error) {
+	defer close(r.core.stopped)
 
-	for {
+	r.core.init(ctx)
+	r.core.startSnapshotWriter()
+	for ctx.Err() == nil {
 		r.core.run(ctx)
-		if ctx.Err() != nil {
-			return
-		}
+	}
+	r.core.waitShutdown()
+	r.core.stopSnapshotWriter()
+
+	r.mut.Lock()
+	if !r.stopping {
+		r.err = parentErr()
+	}
+	r.mut.Unlock()
+}
+
+// Stop leaves the cluster, stops the Runner and waits until it is done
+func (r *Runner) Stop(ctx context.Context) error {
+	r.mut.Lock()
+	cancel := r.cancel
+	r.stopping = r.stopping || cancel != nil
+	r.mut.Unlock()
+
+	if cancel == nil {
+		return ErrNotStarted
+	}
+
+	err := r.Leave(ctx)
+	cancel()
+	if err == ErrStopped {
+		err = nil
+	}
+
+	select {
+	case <-r.core.stopped:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
 	}
 }
 
-// Update ...
+// Done returns a channel closed when the Runner is stopped
+func (r *Runner) Done() <-chan struct{} {
+	return r.core.stopped
+}
+
+// Err returns nil when the Runner is running or was stopped by Stop,
+// and the error of the context passed to Run or Start when it was cancelled
+func (r *Runner) Err() error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	return r.err
+}
+
+// Wait blocks until the Runner is stopped and returns Err
+func (r *Runner) Wait() error {
+	<-r.Done()
+	return r.Err()
+}
+
+// Update merges state and returns the state of this node, or nil when UpdateState returns an error.
+//
+// Deprecated: use UpdateState, which tells why the state could not be merged
 func (r *Runner) Update(ctx context.Context, state State) State {
+	result, _ := r.UpdateState(ctx, state)
+	return result
+}
+
+// UpdateState merges state, received from another node, and returns the state of this node.
+// It waits for the queue of updates until ctx is done,
+// or returns ErrOverloaded when the queue is full with WithFailFastUpdates
+func (r *Runner) UpdateState(ctx context.Context, state State) (State, error) {
+	if err := r.core.checkStarted(); err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	select {
+	case <-r.core.stopped:
+		return nil, ErrStopped
+	default:
+	}
+
 	respChan := make(chan State, 1)
-	r.core.updateChan <- updateRequest{
-		state:    state,
-		respChan: respChan,
+	if err := r.enqueueUpdate(ctx, updateRequest{state: state, respChan: respChan}); err != nil {
+		return nil, err
 	}
+
 	select {
 	case result := <-respChan:
-		return result
+		return result, nil
 	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-r.core.stopped:
+		return nil, ErrStopped
+	}
+}
+
+// enqueueUpdate sends req to the core, without waiting when the queue is full with WithFailFastUpdates
+func (r *Runner) enqueueUpdate(ctx context.Context, req updateRequest) error {
+	if r.core.options.failFastUpdates {
+		select {
+		case r.core.updateChan <- req:
+			return nil
+		default:
+			r.core.options.metrics.UpdateRejected()
+			return ErrOverloaded
+		}
+	}
+
+	select {
+	case r.core.updateChan <- req:
 		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-r.core.stopped:
+		return ErrStopped
 	}
 }
 
+// runAction runs action on the core goroutine
+func (r *Runner) runAction(ctx context.Context, action func(s *coreService, ctx context.Context)) error {
+	if err := r.core.checkStarted(); err != nil {
+		return err
+	}
+	return r.core.runAction(ctx, action)
+}
+
+// StepDown renews the timestamp of this node, making it the youngest member,
+// so that the leadership moves to the next oldest member
+func (r *Runner) StepDown(ctx context.Context) error {
+	return r.runAction(ctx, (*coreService).stepDown)
+}
+
+// Leave marks this node out of sync and broadcasts it to the remote addresses.
+// After leaving, the node keeps syncing but is never elected and never runs Start again,
+// its watchers report the oldest other member, or an empty leader if there is none
+func (r *Runner) Leave(ctx context.Context) error {
+	return r.runAction(ctx, (*coreService).leave)
+}
+
+// IsMember returns true if addr is the address of a member in sync or a remote address of the options
+func (r *Runner) IsMember(ctx context.Context, addr string) (bool, error) {
+	var member bool
+	err := r.runAction(ctx, func(s *coreService, _ context.Context) {
+		member = s.isMember(addr)
+	})
+	if err != nil {
+		return false, err
+	}
+	return member, nil
+}
+
 // NewLeaderWatcher creates a watcher
 func (r *Runner) NewLeaderWatcher() *LeaderWatcher {
 	return &LeaderWatcher{
@@ -98,17 +512,25 @@ func (r *Runner) NewLeaderWatcher() *LeaderWatcher {
 	}
 }
 
-// Watch ...
-func (w *LeaderWatcher) Watch(ctx context.Context) string {
-	ch := w.coreWatcher.watch(w.lastLeader)
-	select {
-	case leader := <-ch:
-		w.lastLeader = leader
-		return leader
-
-	case <-ctx.Done():
-		return ""
+// Next blocks until the leader differs from the one returned by the previous call, and returns it
+func (w *LeaderWatcher) Next(ctx context.Context) (string, error) {
+	if err := w.coreWatcher.core.checkStarted(); err != nil {
+		return "", err
+	}
+	leader, err := w.coreWatcher.next(ctx, w.lastLeader)
+	if err != nil {
+		return "", err
 	}
+	w.lastLeader = leader
+	return leader, nil
+}
+
+// Watch returns the next leader, or an empty string when Next returns an error.
+//
+// Deprecated: use Next, which tells why no leader is returned
+func (w *LeaderWatcher) Watch(ctx context.Context) string {
+	leader, _ := w.Next(ctx)
+	return leader
 }
 
 func boolLess(a, b bool) bool {
@@ -143,28 +565,16 @@ func entryLess(a, b Entry) bool {
 		return false
 	}
 
-	return boolLess(a.OutOfSync, b.OutOfSync)
-}
-
-func combineStates(a, b State) State {
-	result := map[string]Entry{}
-	for k, v := range a {
-		result[k] = v
+	if a.OutOfSync != b.OutOfSync {
+		return boolLess(a.OutOfSync, b.OutOfSync)
 	}
-	for k, v := range b {
-		previous, existed := result[k]
-		if existed {
-			if !entryLess(previous, v) {
-				continue
-			}
-		}
-		result[k] = v
-	}
-	return result
+	return boolLess(a.Suspect, b.Suspect)
 }
 
-func (s State) checkUpdated(addr string, entry Entry) (uint64, bool) {
-	previous, existed := s[addr]
+// checkUpdated returns true if entry is newer than the one of addr,
+// otherwise the term that makes it newer
+func checkUpdated(s entryReader, addr string, entry Entry) (uint64, bool) {
+	previous, existed := s.get(addr)
 	if !existed {
 		return 0, true
 	}
@@ -183,15 +593,8 @@ func (s State) checkUpdated(addr string, entry Entry) (uint64, bool) {
 	return seq + 1, false
 }
 
-func (s State) putEntry(addr string, entry Entry) State {
-	result := map[string]Entry{}
-	for k, v := range s {
-		result[k] = v
-	}
-	result[addr] = entry
-	return result
-}
-
+// nodeID identifies a node, addr is its key in the State:
+// the node ID when configured, otherwise its network address
 type nodeID struct {
 	timestamp uint64
 	addr      string
@@ -223,35 +626,48 @@ func (s sortNodeID) Swap(i, j int) {
 	s[j], s[i] = s[i], s[j]
 }
 
-func (s State) computeLeader(selfAddr string, minTime time.Time, lastUpdate map[string]time.Time) nodeID {
+// eligibleNodes returns the nodes that can be the leader, sorted by seniority
+func eligibleNodes(
+	s entryReader, selfAddr string, selfEligible bool,
+	now time.Time, lastUpdate map[string]time.Time, detector FailureDetector,
+) []nodeID {
 	var nodeIDs []nodeID
-	nodeIDs = append(nodeIDs, nodeID{
-		timestamp: s[selfAddr].Timestamp,
-		addr:      selfAddr,
-	})
+	if selfEligible {
+		self, _ := s.get(selfAddr)
+		nodeIDs = append(nodeIDs, nodeID{
+			timestamp: self.Timestamp,
+			addr:      selfAddr,
+		})
+	}
 
-	for addr, e := range s {
-		if addr == selfAddr {
-			continue
-		}
-		if e.OutOfSync {
-			continue
+	s.each(func(addr string, e Entry) {
+		if addr == selfAddr || e.OutOfSync || e.Suspect {
+			return
 		}
 		lastTime, ok := lastUpdate[addr]
 		if !ok {
-			continue
+			return
 		}
 
-		// now >= t + 30 => false
-		// now - 30 >= t => false
-		// t > now - 30 => true
-		if lastTime.After(minTime) {
+		if detector.Deadline(addr, lastTime).After(now) {
 			nodeIDs = append(nodeIDs, nodeID{
 				timestamp: e.Timestamp,
 				addr:      addr,
 			})
 		}
-	}
+	})
 	sort.Sort(sortNodeID(nodeIDs))
+	return nodeIDs
+}
+
+// computeLeader returns the oldest eligible node, the zero nodeID if there is none
+func computeLeader(
+	s entryReader, selfAddr string, selfEligible bool,
+	now time.Time, lastUpdate map[string]time.Time, detector FailureDetector,
+) nodeID {
+	nodeIDs := eligibleNodes(s, selfAddr, selfEligible, now, lastUpdate, detector)
+	if len(nodeIDs) == 0 {
+		return nodeID{}
+	}
 	return nodeIDs[0]
 }
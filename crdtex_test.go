@@ -1,11 +1,66 @@
 package crdtex
 
 import (
+	"context"
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+// the helpers below are the map based versions of the stateTrie methods used by the core
+
+// combineStates returns the newest entries of a and b.
+// States are copied on write: a itself is returned when b has no newer entry
+func combineStates(a, b State) State {
+	result, _ := mergeStates(a, b)
+	return result
+}
+
+// mergeStates returns the newest entries of a and b, and the keys of the entries of b newer than a
+func mergeStates(a, b State) (State, []string) {
+	var changed []string
+	for k, v := range b {
+		previous, existed := a[k]
+		if existed && !entryLess(previous, v) {
+			continue
+		}
+		changed = append(changed, k)
+	}
+	if len(changed) == 0 && a != nil {
+		return a, nil
+	}
+
+	result := make(State, len(a)+len(changed))
+	for k, v := range a {
+		result[k] = v
+	}
+	for _, k := range changed {
+		result[k] = b[k]
+	}
+	return result, changed
+}
+
+func (s State) checkUpdated(addr string, entry Entry) (uint64, bool) {
+	return checkUpdated(s, addr, entry)
+}
+
+func (s State) putEntry(addr string, entry Entry) State {
+	result := make(State, len(s)+1)
+	for k, v := range s {
+		result[k] = v
+	}
+	result[addr] = entry
+	return result
+}
+
+func (s State) computeLeader(
+	selfAddr string, now time.Time, lastUpdate map[string]time.Time, detector FailureDetector,
+) nodeID {
+	return computeLeader(s, selfAddr, true, now, lastUpdate, detector)
+}
+
 func TestBoolLess(t *testing.T) {
 	table := []struct {
 		name     string
@@ -227,6 +282,68 @@ func TestEntryLess(t *testing.T) {
 			},
 			expected: true,
 		},
+		{
+			name: "suspect-less",
+			a: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   20,
+			},
+			b: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   20,
+				Suspect:   true,
+			},
+			expected: true,
+		},
+		{
+			name: "suspect-less-than-out-of-sync",
+			a: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   20,
+				Suspect:   true,
+			},
+			b: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   20,
+				OutOfSync: true,
+			},
+			expected: true,
+		},
+		{
+			name: "out-of-sync-greater-than-suspect",
+			a: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   20,
+				OutOfSync: true,
+			},
+			b: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   20,
+				Suspect:   true,
+			},
+			expected: false,
+		},
+		{
+			name: "suspect-version-less",
+			a: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   20,
+				Suspect:   true,
+			},
+			b: Entry{
+				Term:      10,
+				Timestamp: 100,
+				Version:   21,
+			},
+			expected: true,
+		},
 	}
 	for _, tc := range table {
 		e := tc
@@ -569,6 +686,35 @@ func TestCombineStates(t *testing.T) {
 	}
 }
 
+func TestCombineStates__Copy_On_Write(t *testing.T) {
+	t.Parallel()
+
+	a := State{
+		"addr-1": {Term: 1, Timestamp: 10, Version: 2},
+		"addr-2": {Term: 1, Timestamp: 20, Version: 3},
+	}
+
+	result, changed := mergeStates(a, State{
+		"addr-1": {Term: 1, Timestamp: 10, Version: 1},
+		"addr-2": {Term: 1, Timestamp: 20, Version: 3},
+	})
+	assert.Equal(t, 0, len(changed))
+	result["addr-3"] = Entry{}
+	assert.Equal(t, Entry{}, a["addr-3"], "the same State is returned")
+	delete(a, "addr-3")
+
+	result, changed = mergeStates(a, State{
+		"addr-2": {Term: 1, Timestamp: 20, Version: 4},
+		"addr-3": {Term: 1, Timestamp: 30, Version: 1},
+	})
+	assert.ElementsMatch(t, []string{"addr-2", "addr-3"}, changed)
+	assert.Equal(t, 3, len(result))
+	assert.Equal(t, State{
+		"addr-1": {Term: 1, Timestamp: 10, Version: 2},
+		"addr-2": {Term: 1, Timestamp: 20, Version: 3},
+	}, a)
+}
+
 func TestCheckUpdated(t *testing.T) {
 	table := []struct {
 		name     string
@@ -959,6 +1105,27 @@ func TestComputeLeader(t *testing.T) {
 				addr:      "address-1",
 			},
 		},
+		{
+			name:     "existing-node-suspect",
+			selfAddr: "address-1",
+			state: map[string]Entry{
+				"address-1": {
+					Timestamp: 100,
+				},
+				"address-2": {
+					Timestamp: 80,
+					Suspect:   true,
+				},
+			},
+			minTime: mustParse("2021-06-05T10:20:00Z"),
+			lastUpdate: map[string]time.Time{
+				"address-2": mustParse("2021-06-05T10:20:01Z"),
+			},
+			expected: nodeID{
+				timestamp: 100,
+				addr:      "address-1",
+			},
+		},
 		{
 			name:     "same-timestamp",
 			selfAddr: "address-1",
@@ -993,8 +1160,469 @@ func TestComputeLeader(t *testing.T) {
 		t.Run(e.name, func(t *testing.T) {
 			t.Parallel()
 
-			result := e.state.computeLeader(e.selfAddr, e.minTime, e.lastUpdate)
+			detector := NewFixedFailureDetector(30 * time.Second)
+			now := e.minTime.Add(30 * time.Second)
+			result := e.state.computeLeader(e.selfAddr, now, e.lastUpdate, detector)
 			assert.Equal(t, e.expected, result)
 		})
 	}
 }
+
+type proberStub struct {
+	pingErr         error
+	pingIndirectErr map[string]error
+
+	// pingTimesOut makes Ping wait for the end of its context
+	pingTimesOut bool
+}
+
+func (p proberStub) Start(context.Context) {}
+
+func (p proberStub) UpdateRemote(context.Context, string, State) (State, error) {
+	return nil, nil
+}
+
+func (p proberStub) Ping(ctx context.Context, _ string) error {
+	if p.pingTimesOut {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return p.pingErr
+}
+
+func (p proberStub) PingIndirect(ctx context.Context, via string, _ string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return p.pingIndirectErr[via]
+}
+
+func TestInterfaceCallbacks_Probe(t *testing.T) {
+	errPing := errors.New("ping error")
+	table := []struct {
+		name     string
+		prober   proberStub
+		via      []string
+		expected bool
+	}{
+		{
+			name:     "direct-ok",
+			prober:   proberStub{},
+			expected: true,
+		},
+		{
+			name:     "direct-failed-no-indirect",
+			prober:   proberStub{pingErr: errPing},
+			expected: false,
+		},
+		{
+			name: "direct-failed-indirect-ok",
+			prober: proberStub{
+				pingErr: errPing,
+				pingIndirectErr: map[string]error{
+					"address-2": errPing,
+				},
+			},
+			via:      []string{"address-2", "address-3"},
+			expected: true,
+		},
+		{
+			name: "all-failed",
+			prober: proberStub{
+				pingErr: errPing,
+				pingIndirectErr: map[string]error{
+					"address-2": errPing,
+					"address-3": errPing,
+				},
+			},
+			via:      []string{"address-2", "address-3"},
+			expected: false,
+		},
+	}
+
+	for _, tc := range table {
+		e := tc
+		t.Run(e.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := interfaceCallbacks{
+				iface:             e.prober,
+				callRemoteTimeout: time.Second,
+			}
+			resultChan := make(chan probeResult, 1)
+			c.probe(context.Background(), "address-1", e.via, resultChan)
+
+			result := <-resultChan
+			assert.Equal(t, probeResult{addr: "address-1", ok: e.expected}, result)
+		})
+	}
+}
+
+func TestInterfaceCallbacks_Probe__Direct_Timeout_Indirect_OK(t *testing.T) {
+	t.Parallel()
+
+	c := interfaceCallbacks{
+		iface:             proberStub{pingTimesOut: true},
+		callRemoteTimeout: 20 * time.Millisecond,
+	}
+	resultChan := make(chan probeResult, 1)
+	c.probe(context.Background(), "address-1", []string{"address-2"}, resultChan)
+
+	result := <-resultChan
+	assert.Equal(t, probeResult{addr: "address-1", ok: true}, result)
+}
+
+func TestRunner_Probing__Detect_Down_Node_Before_Expire(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	addrs := []string{"address-1", "address-2", "address-3"}
+
+	var runners []*Runner
+	for _, addr := range addrs {
+		options := []Option{
+			WithSyncDuration(10 * time.Millisecond),
+			WithExpireDuration(time.Minute),
+			WithProbing(1, 100*time.Millisecond),
+		}
+		for _, remote := range addrs {
+			if remote != addr {
+				options = append(options, AddRemoteAddress(remote))
+			}
+		}
+		runners = append(runners, net.newRunner(addr, options...))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, r := range runners {
+		assert.Equal(t, nil, r.Start(ctx))
+	}
+
+	assert.Eventually(t, func() bool {
+		return len(runners[0].Update(ctx, nil)) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	net.setDown("address-3", true)
+
+	assert.Eventually(t, func() bool {
+		return runners[0].Update(ctx, nil)["address-3"].OutOfSync &&
+			runners[1].Update(ctx, nil)["address-3"].OutOfSync
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, false, runners[0].Update(ctx, nil)["address-2"].OutOfSync)
+}
+
+type queueRecorder struct {
+	noopMetrics
+	depths   []int
+	rejected int32
+}
+
+func (m *queueRecorder) UpdateQueueDepth(depth int) {
+	m.depths = append(m.depths, depth)
+}
+
+func (m *queueRecorder) UpdateRejected() {
+	atomic.AddInt32(&m.rejected, 1)
+}
+
+func TestRunner_UpdateState__Fail_Fast_Overloaded(t *testing.T) {
+	t.Parallel()
+
+	metrics := &queueRecorder{}
+	r := NewRunner(&memoryTransport{net: newMemoryNetwork(), self: "address-1"}, "address-1",
+		WithMetrics(metrics),
+		WithFailFastUpdates(),
+	)
+	close(r.core.started) // started but stalled
+	for i := 0; i < updateChanSize; i++ {
+		r.core.updateChan <- updateRequest{respChan: make(chan State, 1)}
+	}
+
+	result, err := r.UpdateState(context.Background(), State{})
+	assert.Equal(t, ErrOverloaded, err)
+	assert.Equal(t, State(nil), result)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&metrics.rejected))
+	assert.Equal(t, State(nil), r.Update(context.Background(), State{}))
+}
+
+func TestRunner_UpdateState__Full_Queue_Waits(t *testing.T) {
+	t.Parallel()
+
+	metrics := &queueRecorder{}
+	r := NewRunner(&memoryTransport{net: newMemoryNetwork(), self: "address-1"}, "address-1",
+		WithMetrics(metrics),
+	)
+	close(r.core.started) // started but stalled
+	for i := 0; i < updateChanSize; i++ {
+		r.core.updateChan <- updateRequest{respChan: make(chan State, 1)}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	result, err := r.UpdateState(ctx, State{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, State(nil), result)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&metrics.rejected))
+
+	// enqueued once the core handles a queued update
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := r.UpdateState(context.Background(), State{})
+		errChan <- err
+	}()
+	<-r.core.updateChan
+	assert.Eventually(t, func() bool {
+		return len(r.core.updateChan) == updateChanSize
+	}, time.Second, time.Millisecond)
+
+	// the waiting callers return when the core stops
+	close(r.core.stopped)
+	assert.Equal(t, ErrStopped, <-errChan)
+}
+
+func TestRunner_UpdateState__Stalled_Core_Honors_Context(t *testing.T) {
+	t.Parallel()
+
+	r := NewRunner(&memoryTransport{net: newMemoryNetwork(), self: "address-1"}, "address-1")
+	close(r.core.started) // started but stalled
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := r.UpdateState(ctx, State{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	_, err = r.UpdateState(ctx, State{})
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 1, len(r.core.updateChan))
+}
+
+func TestRunner_UpdateState__Stopped(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	r := net.newRunner("address-1")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Equal(t, nil, r.Start(ctx))
+
+	result, err := r.UpdateState(context.Background(), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(result))
+
+	cancel()
+	<-r.Done()
+
+	_, err = r.UpdateState(context.Background(), nil)
+	assert.Equal(t, ErrStopped, err)
+}
+
+func TestCoreService_Update_Queue_Depth(t *testing.T) {
+	t.Parallel()
+
+	metrics := &queueRecorder{}
+	s := newCoreService(newCallbacksMock(), nodeID{timestamp: 100, addr: "self-addr"},
+		computeOptions(WithMetrics(metrics)),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.init(context.Background())
+
+	var respChans []chan State
+	for _, addr := range []string{"remote-addr-1", "remote-addr-2", "remote-addr-3"} {
+		respChan := make(chan State, 1)
+		respChans = append(respChans, respChan)
+		s.updateChan <- updateRequest{
+			state:    State{addr: {Term: 1, Timestamp: 200, Version: 1}},
+			respChan: respChan,
+		}
+	}
+	s.run(context.Background())
+	assert.Equal(t, []int{2}, metrics.depths)
+
+	// the queued requests are merged in a batch and receive the same response
+	for _, respChan := range respChans {
+		assert.Equal(t, 4, len(<-respChan))
+	}
+}
+
+func TestRunner_Errors__Not_Started(t *testing.T) {
+	t.Parallel()
+
+	r := NewRunner(&memoryTransport{net: newMemoryNetwork(), self: "address-1"}, "address-1")
+	// returned without waiting for ctx
+	ctx := context.Background()
+
+	_, err := r.UpdateState(ctx, State{})
+	assert.Equal(t, ErrNotStarted, err)
+
+	assert.Equal(t, ErrNotStarted, r.StepDown(ctx))
+	_, err = r.DebugInfo(ctx)
+	assert.Equal(t, ErrNotStarted, err)
+	_, _, err = r.Get(ctx, "key")
+	assert.Equal(t, ErrNotStarted, err)
+
+	leader, err := r.NewLeaderWatcher().Next(ctx)
+	assert.Equal(t, ErrNotStarted, err)
+	assert.Equal(t, "", leader)
+	_, err = r.NewKVWatcher().Next(ctx)
+	assert.Equal(t, ErrNotStarted, err)
+}
+
+func TestRunner_Errors__Context_And_Stopped(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	r := net.newRunner("address-1", WithSyncDuration(10*time.Millisecond))
+
+	runCtx, stop := context.WithCancel(context.Background())
+	assert.Equal(t, nil, r.Start(runCtx))
+
+	watcher := r.NewLeaderWatcher()
+	leader, err := watcher.Next(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "address-1", leader)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = watcher.Next(ctx)
+	assert.Equal(t, context.Canceled, err)
+	_, err = r.NewKVWatcher().Next(ctx)
+	assert.Equal(t, context.Canceled, err)
+
+	stop()
+	<-r.Done()
+
+	_, err = r.NewLeaderWatcher().Next(context.Background())
+	assert.Equal(t, ErrStopped, err)
+	_, err = r.NewKVWatcher().Next(context.Background())
+	assert.Equal(t, ErrStopped, err)
+	assert.Equal(t, ErrStopped, r.Set(context.Background(), "key", "value"))
+	_, err = r.DebugInfo(context.Background())
+	assert.Equal(t, ErrStopped, err)
+	assert.Equal(t, "", r.NewLeaderWatcher().Watch(context.Background()))
+}
+
+// slowStartTransport is a memoryTransport whose Start returns after the given delay once cancelled
+type slowStartTransport struct {
+	*memoryTransport
+	delay    time.Duration
+	returned chan struct{}
+}
+
+func (t *slowStartTransport) Start(ctx context.Context) {
+	<-ctx.Done()
+	time.Sleep(t.delay)
+	close(t.returned)
+}
+
+func newSlowStartRunner(net *memoryNetwork, addr string, delay time.Duration, options ...Option) (*Runner, chan struct{}) {
+	returned := make(chan struct{})
+	transport := &slowStartTransport{
+		memoryTransport: &memoryTransport{net: net, self: addr},
+		delay:           delay,
+		returned:        returned,
+	}
+	r := NewRunner(transport, addr, options...)
+
+	net.mut.Lock()
+	net.runners[addr] = r
+	net.mut.Unlock()
+
+	return r, returned
+}
+
+func TestRunner_Start_Stop(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	r1, returned := newSlowStartRunner(net, "address-1", 20*time.Millisecond,
+		WithSyncDuration(10*time.Millisecond), AddRemoteAddress("address-2"))
+	r2 := net.newRunner("address-2",
+		WithSyncDuration(10*time.Millisecond), AddRemoteAddress("address-1"))
+
+	assert.Equal(t, nil, r1.Start(context.Background()))
+	assert.Equal(t, nil, r2.Start(context.Background()))
+	defer func() { _ = r2.Stop(context.Background()) }()
+
+	leader, err := r1.NewLeaderWatcher().Next(context.Background())
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "address-1", leader)
+
+	assert.Equal(t, nil, r1.Set(context.Background(), "key", "value"))
+	watcher := r2.NewKVWatcher()
+	for {
+		changes, err := watcher.Next(context.Background())
+		assert.Equal(t, nil, err)
+		if len(changes) > 0 {
+			break
+		}
+	}
+
+	assert.Equal(t, nil, r1.Stop(context.Background()))
+
+	select {
+	case <-returned:
+	default:
+		t.Fatal("Stop returned before the leader Start")
+	}
+	select {
+	case <-r1.Done():
+	default:
+		t.Fatal("Done is not closed after Stop")
+	}
+	assert.Equal(t, nil, r1.Err())
+	assert.Equal(t, nil, r1.Wait())
+
+	// the shutdown is already broadcast
+	state, err := r2.UpdateState(context.Background(), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, state["address-1"].OutOfSync)
+
+	// stopping again does nothing
+	assert.Equal(t, nil, r1.Stop(context.Background()))
+}
+
+func TestRunner_Start__Errors(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	r, returned := newSlowStartRunner(net, "address-1", 50*time.Millisecond,
+		WithSyncDuration(10*time.Millisecond))
+
+	assert.Equal(t, ErrNotStarted, r.Stop(context.Background()))
+	assert.Equal(t, nil, r.Err())
+
+	assert.Equal(t, nil, r.Start(context.Background()))
+	assert.Equal(t, ErrAlreadyStarted, r.Start(context.Background()))
+	r.Run(context.Background())
+
+	_, err := r.NewLeaderWatcher().Next(context.Background())
+	assert.Equal(t, nil, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, r.Stop(ctx))
+
+	assert.Equal(t, nil, r.Wait())
+	<-returned
+}
+
+func TestRunner_Wait__Context_Cancelled(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	r := net.newRunner("address-1", WithSyncDuration(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.Equal(t, nil, r.Start(ctx))
+	assert.Equal(t, nil, r.Err())
+
+	cancel()
+	assert.Equal(t, context.Canceled, r.Wait())
+	assert.Equal(t, context.Canceled, r.Err())
+	assert.Equal(t, nil, r.Stop(context.Background()))
+	assert.Equal(t, context.Canceled, r.Err())
+}
@@ -0,0 +1,69 @@
+// Package crdtexotel implements crdtex.Tracer using OpenTelemetry
+package crdtexotel
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuangTung97/crdtex"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+var _ crdtex.Tracer = tracer{}
+
+// NewTracer creates a crdtex.Tracer from an OpenTelemetry tracer
+func NewTracer(t trace.Tracer) crdtex.Tracer {
+	return tracer{tracer: t}
+}
+
+// StartSpan implements crdtex.Tracer
+func (t tracer) StartSpan(ctx context.Context, name string) (context.Context, crdtex.Span) {
+	ctx, s := t.tracer.Start(ctx, name)
+	return ctx, span{span: s}
+}
+
+type span struct {
+	span trace.Span
+}
+
+var _ crdtex.Span = span{}
+
+func toAttribute(key string, value interface{}) attribute.KeyValue {
+	switch v := value.(type) {
+	case string:
+		return attribute.String(key, v)
+	case int:
+		return attribute.Int(key, v)
+	case int64:
+		return attribute.Int64(key, v)
+	case uint64:
+		return attribute.Int64(key, int64(v))
+	case bool:
+		return attribute.Bool(key, v)
+	case float64:
+		return attribute.Float64(key, v)
+	default:
+		return attribute.String(key, fmt.Sprint(v))
+	}
+}
+
+// SetAttribute implements crdtex.Span
+func (s span) SetAttribute(key string, value interface{}) {
+	s.span.SetAttributes(toAttribute(key, value))
+}
+
+// RecordError implements crdtex.Span, it also sets the status of the span to error
+func (s span) RecordError(err error) {
+	s.span.RecordError(err)
+	s.span.SetStatus(codes.Error, err.Error())
+}
+
+// End implements crdtex.Span
+func (s span) End() {
+	s.span.End()
+}
@@ -0,0 +1,78 @@
+package crdtexotel
+
+import (
+	"context"
+	"errors"
+	"github.com/QuangTung97/crdtex"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func newTestTracer() (crdtex.Tracer, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	return NewTracer(provider.Tracer("crdtex")), exporter
+}
+
+func TestTracer(t *testing.T) {
+	t.Parallel()
+
+	tracer, exporter := newTestTracer()
+
+	ctx, round := tracer.StartSpan(context.Background(), crdtex.SpanSyncRound)
+	round.SetAttribute(crdtex.AttrStateSize, 3)
+	round.SetAttribute(crdtex.AttrPeer, "address-1")
+
+	_, update := tracer.StartSpan(ctx, crdtex.SpanUpdateRemote)
+	update.SetAttribute(crdtex.AttrOutcome, "ok")
+	update.End()
+	round.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 2, len(spans))
+
+	assert.Equal(t, crdtex.SpanUpdateRemote, spans[0].Name)
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String(crdtex.AttrOutcome, "ok"),
+	}, spans[0].Attributes)
+
+	assert.Equal(t, crdtex.SpanSyncRound, spans[1].Name)
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.Int(crdtex.AttrStateSize, 3),
+		attribute.String(crdtex.AttrPeer, "address-1"),
+	}, spans[1].Attributes)
+
+	assert.Equal(t, spans[1].SpanContext.SpanID(), spans[0].Parent.SpanID())
+	assert.Equal(t, spans[1].SpanContext.TraceID(), spans[0].SpanContext.TraceID())
+}
+
+func TestTracer_RecordError(t *testing.T) {
+	t.Parallel()
+
+	tracer, exporter := newTestTracer()
+
+	_, s := tracer.StartSpan(context.Background(), crdtex.SpanUpdateRemote)
+	s.RecordError(errors.New("connection refused"))
+	s.End()
+
+	spans := exporter.GetSpans()
+	assert.Equal(t, 1, len(spans))
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "connection refused", spans[0].Status.Description)
+	assert.Equal(t, 1, len(spans[0].Events))
+	assert.Equal(t, "exception", spans[0].Events[0].Name)
+}
+
+func TestToAttribute(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, attribute.String("k", "v"), toAttribute("k", "v"))
+	assert.Equal(t, attribute.Int("k", 10), toAttribute("k", 10))
+	assert.Equal(t, attribute.Int64("k", 12), toAttribute("k", uint64(12)))
+	assert.Equal(t, attribute.Bool("k", true), toAttribute("k", true))
+	assert.Equal(t, attribute.String("k", "[a b]"), toAttribute("k", []string{"a", "b"}))
+}
@@ -0,0 +1,190 @@
+// Package crdtexprom exposes the metrics of crdtex as Prometheus collectors
+package crdtexprom
+
+import (
+	"github.com/QuangTung97/crdtex"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// Metrics implements crdtex.Metrics and prometheus.Collector
+type Metrics struct {
+	syncAttempts  *prometheus.CounterVec
+	syncSuccesses *prometheus.CounterVec
+	syncFailures  *prometheus.CounterVec
+
+	mergeDuration prometheus.Histogram
+	stateSize     prometheus.Gauge
+
+	gossipSentBytes  *prometheus.CounterVec
+	gossipSavedBytes *prometheus.CounterVec
+
+	leaderChanges prometheus.Counter
+	memberExpires *prometheus.CounterVec
+	runnerStarts  prometheus.Counter
+
+	updateQueueDepth prometheus.Gauge
+	updateRejections prometheus.Counter
+}
+
+var _ crdtex.Metrics = &Metrics{}
+var _ prometheus.Collector = &Metrics{}
+
+// NewMetrics creates Metrics with the metric names prefixed by namespace,
+// the result must be registered to a prometheus.Registerer
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		syncAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sync_attempts_total",
+			Help:      "Number of state syncs sent to each peer",
+		}, []string{"peer"}),
+		syncSuccesses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sync_successes_total",
+			Help:      "Number of successful state syncs with each peer",
+		}, []string{"peer"}),
+		syncFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sync_failures_total",
+			Help:      "Number of failed state syncs with each peer",
+		}, []string{"peer"}),
+
+		mergeDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "merge_duration_seconds",
+			Help:      "Duration of merging a received state",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 8),
+		}),
+		stateSize: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "state_size",
+			Help:      "Number of entries in the state",
+		}),
+
+		gossipSentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "gossip_sent_bytes_total",
+			Help:      "Encoded size of the entries sent to each peer with delta gossip",
+		}, []string{"peer"}),
+		gossipSavedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "gossip_saved_bytes_total",
+			Help:      "Encoded size of the entries not sent to each peer because it already had them",
+		}, []string{"peer"}),
+
+		leaderChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "leader_changes_total",
+			Help:      "Number of times the computed leader changed",
+		}),
+		memberExpires: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "member_expires_total",
+			Help:      "Number of times each peer is considered out of sync",
+		}, []string{"peer"}),
+		runnerStarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "runner_starts_total",
+			Help:      "Number of times Start is invoked on this node",
+		}),
+
+		updateQueueDepth: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "update_queue_depth",
+			Help:      "Number of updates waiting in the queue of the core",
+		}),
+		updateRejections: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "update_rejections_total",
+			Help:      "Number of updates rejected because the queue was full",
+		}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.syncAttempts,
+		m.syncSuccesses,
+		m.syncFailures,
+		m.mergeDuration,
+		m.stateSize,
+		m.gossipSentBytes,
+		m.gossipSavedBytes,
+		m.leaderChanges,
+		m.memberExpires,
+		m.runnerStarts,
+		m.updateQueueDepth,
+		m.updateRejections,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// SyncAttempted implements crdtex.Metrics
+func (m *Metrics) SyncAttempted(addr string) {
+	m.syncAttempts.WithLabelValues(addr).Inc()
+}
+
+// SyncSucceeded implements crdtex.Metrics
+func (m *Metrics) SyncSucceeded(addr string) {
+	m.syncSuccesses.WithLabelValues(addr).Inc()
+}
+
+// SyncFailed implements crdtex.Metrics
+func (m *Metrics) SyncFailed(addr string) {
+	m.syncFailures.WithLabelValues(addr).Inc()
+}
+
+// MergeDuration implements crdtex.Metrics
+func (m *Metrics) MergeDuration(d time.Duration) {
+	m.mergeDuration.Observe(d.Seconds())
+}
+
+// StateSize implements crdtex.Metrics
+func (m *Metrics) StateSize(size int) {
+	m.stateSize.Set(float64(size))
+}
+
+// GossipSent implements crdtex.Metrics
+func (m *Metrics) GossipSent(addr string, sentBytes int, savedBytes int) {
+	m.gossipSentBytes.WithLabelValues(addr).Add(float64(sentBytes))
+	m.gossipSavedBytes.WithLabelValues(addr).Add(float64(savedBytes))
+}
+
+// LeaderChanged implements crdtex.Metrics
+func (m *Metrics) LeaderChanged(string) {
+	m.leaderChanges.Inc()
+}
+
+// MemberExpired implements crdtex.Metrics
+func (m *Metrics) MemberExpired(addr string) {
+	m.memberExpires.WithLabelValues(addr).Inc()
+}
+
+// RunnerStarted implements crdtex.Metrics
+func (m *Metrics) RunnerStarted() {
+	m.runnerStarts.Inc()
+}
+
+// UpdateQueueDepth implements crdtex.Metrics
+func (m *Metrics) UpdateQueueDepth(depth int) {
+	m.updateQueueDepth.Set(float64(depth))
+}
+
+// UpdateRejected implements crdtex.Metrics
+func (m *Metrics) UpdateRejected() {
+	m.updateRejections.Inc()
+}
@@ -0,0 +1,106 @@
+package crdtexprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics("crdtex")
+	registry := prometheus.NewRegistry()
+	assert.Equal(t, nil, registry.Register(m))
+
+	m.SyncAttempted("address-1")
+	m.SyncAttempted("address-1")
+	m.SyncAttempted("address-2")
+	m.SyncSucceeded("address-1")
+	m.SyncFailed("address-2")
+	m.StateSize(3)
+	m.GossipSent("address-1", 120, 0)
+	m.GossipSent("address-1", 40, 80)
+	m.LeaderChanged("address-1")
+	m.LeaderChanged("address-2")
+	m.MemberExpired("address-2")
+	m.RunnerStarted()
+	m.UpdateQueueDepth(12)
+	m.UpdateRejected()
+
+	expected := `
+# HELP crdtex_gossip_saved_bytes_total Encoded size of the entries not sent to each peer because it already had them
+# TYPE crdtex_gossip_saved_bytes_total counter
+crdtex_gossip_saved_bytes_total{peer="address-1"} 80
+# HELP crdtex_gossip_sent_bytes_total Encoded size of the entries sent to each peer with delta gossip
+# TYPE crdtex_gossip_sent_bytes_total counter
+crdtex_gossip_sent_bytes_total{peer="address-1"} 160
+# HELP crdtex_leader_changes_total Number of times the computed leader changed
+# TYPE crdtex_leader_changes_total counter
+crdtex_leader_changes_total 2
+# HELP crdtex_member_expires_total Number of times each peer is considered out of sync
+# TYPE crdtex_member_expires_total counter
+crdtex_member_expires_total{peer="address-2"} 1
+# HELP crdtex_runner_starts_total Number of times Start is invoked on this node
+# TYPE crdtex_runner_starts_total counter
+crdtex_runner_starts_total 1
+# HELP crdtex_state_size Number of entries in the state
+# TYPE crdtex_state_size gauge
+crdtex_state_size 3
+# HELP crdtex_sync_attempts_total Number of state syncs sent to each peer
+# TYPE crdtex_sync_attempts_total counter
+crdtex_sync_attempts_total{peer="address-1"} 2
+crdtex_sync_attempts_total{peer="address-2"} 1
+# HELP crdtex_sync_failures_total Number of failed state syncs with each peer
+# TYPE crdtex_sync_failures_total counter
+crdtex_sync_failures_total{peer="address-2"} 1
+# HELP crdtex_sync_successes_total Number of successful state syncs with each peer
+# TYPE crdtex_sync_successes_total counter
+crdtex_sync_successes_total{peer="address-1"} 1
+# HELP crdtex_update_queue_depth Number of updates waiting in the queue of the core
+# TYPE crdtex_update_queue_depth gauge
+crdtex_update_queue_depth 12
+# HELP crdtex_update_rejections_total Number of updates rejected because the queue was full
+# TYPE crdtex_update_rejections_total counter
+crdtex_update_rejections_total 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"crdtex_gossip_saved_bytes_total",
+		"crdtex_gossip_sent_bytes_total",
+		"crdtex_leader_changes_total",
+		"crdtex_member_expires_total",
+		"crdtex_runner_starts_total",
+		"crdtex_state_size",
+		"crdtex_sync_attempts_total",
+		"crdtex_sync_failures_total",
+		"crdtex_sync_successes_total",
+		"crdtex_update_queue_depth",
+		"crdtex_update_rejections_total",
+	)
+	assert.Equal(t, nil, err)
+}
+
+func TestMetrics_MergeDuration(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics("crdtex")
+	registry := prometheus.NewRegistry()
+	assert.Equal(t, nil, registry.Register(m))
+
+	m.MergeDuration(20 * time.Microsecond)
+	m.MergeDuration(3 * time.Millisecond)
+
+	families, err := registry.Gather()
+	assert.Equal(t, nil, err)
+
+	var sampleCount uint64
+	for _, f := range families {
+		if f.GetName() == "crdtex_merge_duration_seconds" {
+			sampleCount = f.GetMetric()[0].GetHistogram().GetSampleCount()
+		}
+	}
+	assert.Equal(t, uint64(2), sampleCount)
+}
@@ -0,0 +1,274 @@
+package crdtex
+
+import (
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DebugInfo is the cluster view of a node
+type DebugInfo struct {
+	Self          string        `json:"self"`
+	Leader        string        `json:"leader"`
+	LeaderRunning bool          `json:"leader_running"`
+	Left          bool          `json:"left"`
+	Bootstrapped  bool          `json:"bootstrapped"`
+	Eligible      []string      `json:"eligible"`
+	Members       []DebugMember `json:"members"`
+	Options       DebugOptions  `json:"options"`
+}
+
+// DebugMember is an entry of the state with its local bookkeeping
+type DebugMember struct {
+	ID        string `json:"id,omitempty"`
+	Addr      string `json:"addr"`
+	Term      uint64 `json:"term"`
+	Timestamp uint64 `json:"timestamp"`
+	Version   uint64 `json:"version"`
+	Suspect   bool   `json:"suspect"`
+	OutOfSync bool   `json:"out_of_sync"`
+
+	LastUpdateAge    *float64 `json:"last_update_age_seconds,omitempty"`
+	LastSyncAge      *float64 `json:"last_sync_age_seconds,omitempty"`
+	SyncErrors       int      `json:"sync_errors"`
+	LocallySuspected bool     `json:"locally_suspected"`
+}
+
+// DebugOptions are the options of a node
+type DebugOptions struct {
+	RemoteAddresses     []string `json:"remote_addresses"`
+	CallRemoteTimeout   string   `json:"call_remote_timeout"`
+	SyncDuration        string   `json:"sync_duration"`
+	ExpireDuration      string   `json:"expire_duration"`
+	LeaseDuration       string   `json:"lease_duration"`
+	MaxClockDrift       string   `json:"max_clock_drift"`
+	MinClusterSize      int      `json:"min_cluster_size"`
+	BootstrapSyncRounds int      `json:"bootstrap_sync_rounds"`
+	ProbeEnabled        bool     `json:"probe_enabled"`
+	IndirectProbes      int      `json:"indirect_probes"`
+	SuspectTimeout      string   `json:"suspect_timeout"`
+	NodeID              string   `json:"node_id"`
+	StoreEnabled        bool     `json:"store_enabled"`
+	SnapshotInterval    string   `json:"snapshot_interval"`
+	HLCEnabled          bool     `json:"hlc_enabled"`
+	DeltaGossip         bool     `json:"delta_gossip"`
+	AntiEntropyRounds   int      `json:"anti_entropy_rounds"`
+	FailFastUpdates     bool     `json:"fail_fast_updates"`
+	CRDTs               []string `json:"crdts"`
+}
+
+type debugInfoRequest struct {
+	respChan chan<- DebugInfo
+}
+
+var _ coreRequest = debugInfoRequest{}
+
+func (req debugInfoRequest) handle(_ context.Context, s *coreService) {
+	req.respChan <- s.debugInfo()
+}
+
+func ageSeconds(now time.Time, times map[string]time.Time, addr string) *float64 {
+	t, ok := times[addr]
+	if !ok {
+		return nil
+	}
+	age := now.Sub(t).Seconds()
+	return &age
+}
+
+func (o serviceOptions) debugOptions() DebugOptions {
+	var crdts []string
+	for name := range o.crdts {
+		crdts = append(crdts, name)
+	}
+	sort.Strings(crdts)
+
+	return DebugOptions{
+		RemoteAddresses:     o.remoteAddresses,
+		CallRemoteTimeout:   o.callRemoteTimeout.String(),
+		SyncDuration:        o.syncDuration.String(),
+		ExpireDuration:      o.expireDuration.String(),
+		LeaseDuration:       o.leaseDuration.String(),
+		MaxClockDrift:       o.maxClockDrift.String(),
+		MinClusterSize:      o.minClusterSize,
+		BootstrapSyncRounds: o.bootstrapSyncRounds,
+		ProbeEnabled:        o.probeEnabled,
+		IndirectProbes:      o.indirectProbes,
+		SuspectTimeout:      o.suspectTimeout.String(),
+		NodeID:              o.nodeID,
+		StoreEnabled:        o.store != nil,
+		SnapshotInterval:    o.snapshotInterval.String(),
+		HLCEnabled:          o.clock != nil,
+		DeltaGossip:         o.deltaGossip,
+		AntiEntropyRounds:   o.antiEntropyRounds,
+		FailFastUpdates:     o.failFastUpdates,
+		CRDTs:               crdts,
+	}
+}
+
+func (s *coreService) debugInfo() DebugInfo {
+	now := s.getNow()
+
+	var eligible []string
+	for _, n := range eligibleNodes(s.state, s.self.addr, !s.left, now, s.lastUpdate, s.options.failureDetector) {
+		eligible = append(eligible, addrOf(s.state, n.addr))
+	}
+
+	members := make([]DebugMember, 0, s.state.len())
+	s.state.each(func(key string, e Entry) {
+		_, suspected := s.suspected[key]
+		addr := addrOf(s.state, key)
+		id := ""
+		if addr != key {
+			id = key
+		}
+		members = append(members, DebugMember{
+			ID:        id,
+			Addr:      addr,
+			Term:      e.Term,
+			Timestamp: e.Timestamp,
+			Version:   e.Version,
+			Suspect:   e.Suspect,
+			OutOfSync: e.OutOfSync,
+
+			LastUpdateAge:    ageSeconds(now, s.lastUpdate, key),
+			LastSyncAge:      ageSeconds(now, s.lastSynced, addr),
+			SyncErrors:       s.syncErrors[addr],
+			LocallySuspected: suspected,
+		})
+	})
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].Addr < members[j].Addr
+	})
+
+	return DebugInfo{
+		Self:          s.selfAddr,
+		Leader:        s.leaderAddr,
+		LeaderRunning: s.runnerIsRunning,
+		Left:          s.left,
+		Bootstrapped:  s.bootstrapped,
+		Eligible:      eligible,
+		Members:       members,
+		Options:       s.options.debugOptions(),
+	}
+}
+
+func (s *coreService) getDebugInfo(ctx context.Context) (DebugInfo, error) {
+	respChan := make(chan DebugInfo, 1)
+	select {
+	case s.requestChan <- debugInfoRequest{respChan: respChan}:
+	case <-ctx.Done():
+		return DebugInfo{}, ctx.Err()
+	case <-s.stopped:
+		return DebugInfo{}, ErrStopped
+	}
+
+	select {
+	case info := <-respChan:
+		return info, nil
+	case <-ctx.Done():
+		return DebugInfo{}, ctx.Err()
+	case <-s.stopped:
+		return DebugInfo{}, ErrStopped
+	}
+}
+
+// DebugInfo returns the current cluster view of the node
+func (r *Runner) DebugInfo(ctx context.Context) (DebugInfo, error) {
+	if err := r.core.checkStarted(); err != nil {
+		return DebugInfo{}, err
+	}
+	return r.core.getDebugInfo(ctx)
+}
+
+// DebugHandler returns a http.Handler rendering DebugInfo as JSON,
+// or as a HTML table when requested with ?format=html or an Accept header of text/html
+func (r *Runner) DebugHandler() http.Handler {
+	return debugHandler{runner: r}
+}
+
+type debugHandler struct {
+	runner *Runner
+}
+
+func formatSeconds(seconds *float64) string {
+	if seconds == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*seconds, 'f', 3, 64)
+}
+
+var debugTemplate = template.Must(template.New("debug").Funcs(template.FuncMap{
+	"seconds": formatSeconds,
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><title>crdtex {{.Self}}</title></head>
+<body>
+<h1>{{.Self}}</h1>
+<p>Leader: <b>{{.Leader}}</b>, running: {{.LeaderRunning}}, bootstrapped: {{.Bootstrapped}}, left: {{.Left}}</p>
+<p>Eligible: {{range $i, $e := .Eligible}}{{if $i}}, {{end}}{{$e}}{{end}}</p>
+<table border="1">
+<tr><th>Addr</th><th>Term</th><th>Timestamp</th><th>Version</th><th>Suspect</th><th>Out Of Sync</th>` +
+	`<th>Last Update Age (s)</th><th>Last Sync Age (s)</th><th>Sync Errors</th><th>Locally Suspected</th></tr>
+{{range .Members}}<tr><td>{{.Addr}}{{if .ID}} ({{.ID}}){{end}}</td><td>{{.Term}}</td><td>{{.Timestamp}}</td><td>{{.Version}}</td>` +
+	`<td>{{.Suspect}}</td><td>{{.OutOfSync}}</td>` +
+	`<td>{{seconds .LastUpdateAge}}</td><td>{{seconds .LastSyncAge}}</td>` +
+	`<td>{{.SyncErrors}}</td><td>{{.LocallySuspected}}</td></tr>
+{{end}}</table>
+<h2>Options</h2>
+<table border="1">
+<tr><td>Remote Addresses</td><td>{{range $i, $e := .Options.RemoteAddresses}}{{if $i}}, {{end}}{{$e}}{{end}}</td></tr>
+<tr><td>Call Remote Timeout</td><td>{{.Options.CallRemoteTimeout}}</td></tr>
+<tr><td>Sync Duration</td><td>{{.Options.SyncDuration}}</td></tr>
+<tr><td>Expire Duration</td><td>{{.Options.ExpireDuration}}</td></tr>
+<tr><td>Lease Duration</td><td>{{.Options.LeaseDuration}}</td></tr>
+<tr><td>Max Clock Drift</td><td>{{.Options.MaxClockDrift}}</td></tr>
+<tr><td>Min Cluster Size</td><td>{{.Options.MinClusterSize}}</td></tr>
+<tr><td>Bootstrap Sync Rounds</td><td>{{.Options.BootstrapSyncRounds}}</td></tr>
+<tr><td>Probe Enabled</td><td>{{.Options.ProbeEnabled}}</td></tr>
+<tr><td>Indirect Probes</td><td>{{.Options.IndirectProbes}}</td></tr>
+<tr><td>Suspect Timeout</td><td>{{.Options.SuspectTimeout}}</td></tr>
+<tr><td>Node ID</td><td>{{.Options.NodeID}}</td></tr>
+<tr><td>Store Enabled</td><td>{{.Options.StoreEnabled}}</td></tr>
+<tr><td>Snapshot Interval</td><td>{{.Options.SnapshotInterval}}</td></tr>
+<tr><td>HLC Enabled</td><td>{{.Options.HLCEnabled}}</td></tr>
+<tr><td>Delta Gossip</td><td>{{.Options.DeltaGossip}}</td></tr>
+<tr><td>Anti-Entropy Rounds</td><td>{{.Options.AntiEntropyRounds}}</td></tr>
+<tr><td>Fail Fast Updates</td><td>{{.Options.FailFastUpdates}}</td></tr>
+<tr><td>CRDTs</td><td>{{range $i, $e := .Options.CRDTs}}{{if $i}}, {{end}}{{$e}}{{end}}</td></tr>
+</table>
+</body>
+</html>
+`))
+
+func wantHTML(req *http.Request) bool {
+	if req.URL.Query().Get("format") == "html" {
+		return true
+	}
+	return strings.Contains(req.Header.Get("Accept"), "text/html")
+}
+
+// ServeHTTP implements http.Handler
+func (h debugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	info, err := h.runner.DebugInfo(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if wantHTML(req) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = debugTemplate.Execute(w, info)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(info)
+}
@@ -0,0 +1,188 @@
+package crdtex
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newDebugRunner() *Runner {
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(newCallbacksMock(), self,
+		computeOptions(
+			AddRemoteAddress("remote-addr-1"),
+			AddRemoteAddress("remote-addr-2"),
+			WithSyncDuration(2*time.Second),
+			WithExpireDuration(30*time.Second),
+		),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:00Z") }
+
+	s.init(context.Background())
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 80, Version: 3},
+		"remote-addr-2": {Term: 2, Timestamp: 90, Version: 5, OutOfSync: true},
+	})
+	s.lastSynced["remote-addr-1"] = mustParse("2021-06-05T10:19:58Z")
+	s.syncErrors["remote-addr-2"] = 4
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:05Z") }
+	s.computeAndStartLeader(context.Background())
+	close(s.started)
+
+	return &Runner{core: s}
+}
+
+func serveDebugOnce(r *Runner, req *http.Request) *httptest.ResponseRecorder {
+	go r.core.run(context.Background())
+
+	w := httptest.NewRecorder()
+	r.DebugHandler().ServeHTTP(w, req)
+	return w
+}
+
+func floatPtr(f float64) *float64 {
+	return &f
+}
+
+func TestDebugHandler_JSON(t *testing.T) {
+	t.Parallel()
+
+	r := newDebugRunner()
+	w := serveDebugOnce(r, httptest.NewRequest(http.MethodGet, "/debug/crdtex", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+
+	var info DebugInfo
+	err := json.Unmarshal(w.Body.Bytes(), &info)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, DebugInfo{
+		Self:          "self-addr",
+		Leader:        "remote-addr-1",
+		LeaderRunning: false,
+		Bootstrapped:  true,
+		Eligible:      []string{"remote-addr-1", "self-addr"},
+		Members: []DebugMember{
+			{
+				Addr:          "remote-addr-1",
+				Term:          1,
+				Timestamp:     80,
+				Version:       3,
+				LastUpdateAge: floatPtr(5),
+				LastSyncAge:   floatPtr(7),
+			},
+			{
+				Addr:          "remote-addr-2",
+				Term:          2,
+				Timestamp:     90,
+				Version:       5,
+				OutOfSync:     true,
+				LastUpdateAge: floatPtr(5),
+				SyncErrors:    4,
+			},
+			{
+				Addr:      "self-addr",
+				Term:      1,
+				Timestamp: 100,
+				Version:   1,
+			},
+		},
+		Options: DebugOptions{
+			RemoteAddresses:   []string{"remote-addr-1", "remote-addr-2"},
+			CallRemoteTimeout: "5s",
+			SyncDuration:      "2s",
+			ExpireDuration:    "30s",
+			LeaseDuration:     "0s",
+			MaxClockDrift:     "0s",
+			SuspectTimeout:    "0s",
+			SnapshotInterval:  "0s",
+		},
+	}, info)
+}
+
+func TestServiceOptions_DebugOptions(t *testing.T) {
+	t.Parallel()
+
+	opts := computeOptions(
+		WithNodeID("node-1"),
+		WithStore(NewFileStore("unused.json"), time.Minute),
+		WithHLC(NewHLC()),
+		WithDeltaGossip(),
+		WithAntiEntropy(10),
+		WithFailFastUpdates(),
+		WithCRDT("set", newGSet()),
+		WithCRDT("count", NewGCounter()),
+	)
+
+	assert.Equal(t, DebugOptions{
+		CallRemoteTimeout: "5s",
+		SyncDuration:      "5s",
+		ExpireDuration:    "1m0s",
+		LeaseDuration:     "0s",
+		MaxClockDrift:     "0s",
+		SuspectTimeout:    "0s",
+		NodeID:            "node-1",
+		StoreEnabled:      true,
+		SnapshotInterval:  "1m0s",
+		HLCEnabled:        true,
+		DeltaGossip:       true,
+		AntiEntropyRounds: 10,
+		FailFastUpdates:   true,
+		CRDTs:             []string{"count", "set"},
+	}, opts.debugOptions())
+}
+
+func TestDebugHandler_HTML(t *testing.T) {
+	t.Parallel()
+
+	r := newDebugRunner()
+	w := serveDebugOnce(r, httptest.NewRequest(http.MethodGet, "/debug/crdtex?format=html", nil))
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	body := w.Body.String()
+	assert.True(t, strings.Contains(body, "Leader: <b>remote-addr-1</b>"))
+	assert.True(t, strings.Contains(body, "Eligible: remote-addr-1, self-addr"))
+	assert.True(t, strings.Contains(body,
+		"<tr><td>remote-addr-2</td><td>2</td><td>90</td><td>5</td><td>false</td><td>true</td>"+
+			"<td>5.000</td><td></td><td>4</td><td>false</td></tr>"))
+}
+
+func TestDebugHandler_Accept_HTML(t *testing.T) {
+	t.Parallel()
+
+	r := newDebugRunner()
+	req := httptest.NewRequest(http.MethodGet, "/debug/crdtex", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	w := serveDebugOnce(r, req)
+
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+}
+
+func TestDebugHandler_Core_Not_Running(t *testing.T) {
+	t.Parallel()
+
+	r := newDebugRunner()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	req := httptest.NewRequest(http.MethodGet, "/debug/crdtex", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	r.DebugHandler().ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+}
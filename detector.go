@@ -0,0 +1,193 @@
+package crdtex
+
+import (
+	"math"
+	"time"
+)
+
+// FailureDetector decides when a remote node is considered expired.
+// All methods are called from the core goroutine only
+type FailureDetector interface {
+	// Heartbeat is called whenever a new entry of addr is received
+	Heartbeat(addr string, now time.Time)
+
+	// Deadline returns the time at which addr is considered expired,
+	// given the time its entry was last updated
+	Deadline(addr string, lastUpdate time.Time) time.Time
+}
+
+type fixedFailureDetector struct {
+	expireDuration time.Duration
+}
+
+var _ FailureDetector = fixedFailureDetector{}
+
+// NewFixedFailureDetector creates a FailureDetector that expires a node
+// after a fixed duration without any update
+func NewFixedFailureDetector(expireDuration time.Duration) FailureDetector {
+	return fixedFailureDetector{
+		expireDuration: expireDuration,
+	}
+}
+
+// Heartbeat does nothing
+func (d fixedFailureDetector) Heartbeat(string, time.Time) {
+}
+
+// Deadline returns lastUpdate + expire duration
+func (d fixedFailureDetector) Deadline(_ string, lastUpdate time.Time) time.Time {
+	return lastUpdate.Add(d.expireDuration)
+}
+
+// PhiAccrualConfig configures the phi accrual failure detector.
+// Zero fields are replaced by default values
+type PhiAccrualConfig struct {
+	// Threshold is the phi value at which a node is considered expired, default 8
+	Threshold float64
+	// MaxSampleSize is the number of inter-arrival times kept per node, default 200
+	MaxSampleSize int
+	// MinStdDeviation is the minimum standard deviation used, default 500ms
+	MinStdDeviation time.Duration
+	// AcceptablePause is added to the mean inter-arrival time, default 0
+	AcceptablePause time.Duration
+	// FirstHeartbeatEstimate is the assumed inter-arrival time of a node before any sample, default 5s
+	FirstHeartbeatEstimate time.Duration
+}
+
+func (c PhiAccrualConfig) withDefaults() PhiAccrualConfig {
+	if c.Threshold == 0 {
+		c.Threshold = 8
+	}
+	if c.MaxSampleSize == 0 {
+		c.MaxSampleSize = 200
+	}
+	if c.MinStdDeviation == 0 {
+		c.MinStdDeviation = 500 * time.Millisecond
+	}
+	if c.FirstHeartbeatEstimate == 0 {
+		c.FirstHeartbeatEstimate = 5 * time.Second
+	}
+	return c
+}
+
+type heartbeatHistory struct {
+	lastTime  time.Time
+	intervals []float64 // in seconds
+	next      int
+
+	sum        float64
+	sumSquared float64
+}
+
+func (h *heartbeatHistory) add(interval float64, maxSize int) {
+	if len(h.intervals) < maxSize {
+		h.intervals = append(h.intervals, interval)
+	} else {
+		old := h.intervals[h.next]
+		h.sum -= old
+		h.sumSquared -= old * old
+		h.intervals[h.next] = interval
+		h.next = (h.next + 1) % maxSize
+	}
+	h.sum += interval
+	h.sumSquared += interval * interval
+}
+
+func (h *heartbeatHistory) mean() float64 {
+	return h.sum / float64(len(h.intervals))
+}
+
+func (h *heartbeatHistory) stdDeviation() float64 {
+	mean := h.mean()
+	variance := h.sumSquared/float64(len(h.intervals)) - mean*mean
+	if variance < 0 {
+		return 0
+	}
+	return math.Sqrt(variance)
+}
+
+type phiAccrualFailureDetector struct {
+	conf      PhiAccrualConfig
+	histories map[string]*heartbeatHistory
+}
+
+var _ FailureDetector = &phiAccrualFailureDetector{}
+
+// NewPhiAccrualFailureDetector creates a phi accrual failure detector,
+// it learns the inter-arrival times of updates for each node
+// and expires a node when phi reaches the configured threshold
+func NewPhiAccrualFailureDetector(conf PhiAccrualConfig) FailureDetector {
+	return &phiAccrualFailureDetector{
+		conf:      conf.withDefaults(),
+		histories: map[string]*heartbeatHistory{},
+	}
+}
+
+func (d *phiAccrualFailureDetector) newHistory(now time.Time) *heartbeatHistory {
+	mean := d.conf.FirstHeartbeatEstimate.Seconds()
+	stdDeviation := mean / 4
+
+	h := &heartbeatHistory{lastTime: now}
+	h.add(mean-stdDeviation, d.conf.MaxSampleSize)
+	h.add(mean+stdDeviation, d.conf.MaxSampleSize)
+	return h
+}
+
+// Heartbeat records the inter-arrival time of addr
+func (d *phiAccrualFailureDetector) Heartbeat(addr string, now time.Time) {
+	h, existed := d.histories[addr]
+	if !existed {
+		d.histories[addr] = d.newHistory(now)
+		return
+	}
+
+	interval := now.Sub(h.lastTime).Seconds()
+	h.lastTime = now
+	if interval <= 0 {
+		return
+	}
+	h.add(interval, d.conf.MaxSampleSize)
+}
+
+func (d *phiAccrualFailureDetector) meanAndStdDeviation(addr string) (float64, float64) {
+	h, existed := d.histories[addr]
+	if !existed {
+		h = d.newHistory(time.Time{})
+	}
+
+	mean := h.mean() + d.conf.AcceptablePause.Seconds()
+	stdDeviation := math.Max(h.stdDeviation(), d.conf.MinStdDeviation.Seconds())
+	return mean, stdDeviation
+}
+
+// phi computes the phi value of addr after elapsed since its last update,
+// using the logistic approximation of the cumulative normal distribution
+func (d *phiAccrualFailureDetector) phi(addr string, elapsed time.Duration) float64 {
+	mean, stdDeviation := d.meanAndStdDeviation(addr)
+	y := (elapsed.Seconds() - mean) / stdDeviation
+	e := math.Exp(-y * (1.5976 + 0.070566*y*y))
+	return -math.Log10(e / (1 + e))
+}
+
+// Deadline returns the time at which phi of addr reaches the threshold
+func (d *phiAccrualFailureDetector) Deadline(addr string, lastUpdate time.Time) time.Time {
+	mean, stdDeviation := d.meanAndStdDeviation(addr)
+
+	// solve: e / (1 + e) = 10^-threshold with e = exp(-y * (1.5976 + 0.070566 * y^2))
+	// <=> 0.070566 * y^3 + 1.5976 * y - c = 0
+	p := math.Pow(10, -d.conf.Threshold)
+	c := -math.Log(p / (1 - p))
+	y := solveDepressedCubic(1.5976/0.070566, -c/0.070566)
+
+	seconds := mean + y*stdDeviation
+	if seconds < 0 {
+		seconds = 0
+	}
+	return lastUpdate.Add(time.Duration(seconds * float64(time.Second)))
+}
+
+// solveDepressedCubic returns the real root of y^3 + p*y + q = 0 with p > 0
+func solveDepressedCubic(p, q float64) float64 {
+	sqrtDelta := math.Sqrt(q*q/4 + p*p*p/27)
+	return math.Cbrt(-q/2+sqrtDelta) + math.Cbrt(-q/2-sqrtDelta)
+}
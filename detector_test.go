@@ -0,0 +1,106 @@
+package crdtex
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFixedFailureDetector(t *testing.T) {
+	t.Parallel()
+
+	d := NewFixedFailureDetector(30 * time.Second)
+	d.Heartbeat("address-1", mustParse("2021-06-05T10:20:00Z"))
+
+	deadline := d.Deadline("address-1", mustParse("2021-06-05T10:20:10Z"))
+	assert.Equal(t, mustParse("2021-06-05T10:20:40Z"), deadline)
+}
+
+func heartbeatEvery(d FailureDetector, addr string, start time.Time, intervals []time.Duration) time.Time {
+	now := start
+	d.Heartbeat(addr, now)
+	for _, interval := range intervals {
+		now = now.Add(interval)
+		d.Heartbeat(addr, now)
+	}
+	return now
+}
+
+func repeatDurations(n int, durations ...time.Duration) []time.Duration {
+	var result []time.Duration
+	for i := 0; i < n; i++ {
+		result = append(result, durations...)
+	}
+	return result
+}
+
+func TestPhiAccrualFailureDetector__Phi_At_Deadline_Equals_Threshold(t *testing.T) {
+	t.Parallel()
+
+	d := NewPhiAccrualFailureDetector(PhiAccrualConfig{}).(*phiAccrualFailureDetector)
+	last := heartbeatEvery(d, "address-1", mustParse("2021-06-05T10:20:00Z"),
+		repeatDurations(20, 800*time.Millisecond, 1200*time.Millisecond))
+
+	deadline := d.Deadline("address-1", last)
+	assert.InDelta(t, 8.0, d.phi("address-1", deadline.Sub(last)), 0.001)
+
+	assert.Less(t, d.phi("address-1", time.Second), 1.0)
+}
+
+func TestPhiAccrualFailureDetector__First_Heartbeat_Estimate(t *testing.T) {
+	t.Parallel()
+
+	d := NewPhiAccrualFailureDetector(PhiAccrualConfig{
+		FirstHeartbeatEstimate: 4 * time.Second,
+	})
+	start := mustParse("2021-06-05T10:20:00Z")
+	d.Heartbeat("address-1", start)
+
+	deadline := d.Deadline("address-1", start)
+	unknownDeadline := d.Deadline("address-2", start)
+	assert.Equal(t, deadline, unknownDeadline)
+
+	// mean = 4s, std deviation = 1s, phi = 8 at y ~ 5.3
+	assert.True(t, deadline.After(start.Add(9*time.Second)))
+	assert.True(t, deadline.Before(start.Add(10*time.Second)))
+}
+
+func TestPhiAccrualFailureDetector__Healthy_Network_Faster_Than_Jittery(t *testing.T) {
+	t.Parallel()
+
+	start := mustParse("2021-06-05T10:20:00Z")
+	conf := PhiAccrualConfig{
+		MaxSampleSize:   50,
+		MinStdDeviation: 100 * time.Millisecond,
+	}
+
+	healthy := NewPhiAccrualFailureDetector(conf)
+	healthyLast := heartbeatEvery(healthy, "address-1", start,
+		repeatDurations(100, 1000*time.Millisecond))
+
+	jittery := NewPhiAccrualFailureDetector(conf)
+	jitteryLast := heartbeatEvery(jittery, "address-1", start,
+		repeatDurations(50, 200*time.Millisecond, 1800*time.Millisecond))
+
+	healthyTimeout := healthy.Deadline("address-1", healthyLast).Sub(healthyLast)
+	jitteryTimeout := jittery.Deadline("address-1", jitteryLast).Sub(jitteryLast)
+
+	assert.Less(t, int64(healthyTimeout), int64(2*time.Second))
+	assert.Greater(t, int64(jitteryTimeout), int64(5*time.Second))
+}
+
+func TestPhiAccrualFailureDetector__Max_Sample_Size(t *testing.T) {
+	t.Parallel()
+
+	d := NewPhiAccrualFailureDetector(PhiAccrualConfig{
+		MaxSampleSize: 4,
+	}).(*phiAccrualFailureDetector)
+
+	heartbeatEvery(d, "address-1", mustParse("2021-06-05T10:20:00Z"),
+		repeatDurations(4, 2*time.Second))
+
+	h := d.histories["address-1"]
+	assert.Equal(t, []float64{2, 2, 2, 2}, h.intervals)
+	assert.InDelta(t, 2.0, h.mean(), 0.0001)
+	assert.InDelta(t, 0.0, h.stdDeviation(), 0.0001)
+}
@@ -5,7 +5,11 @@ go 1.16
 require (
 	github.com/fzipp/gocyclo v0.3.1
 	github.com/kisielk/errcheck v1.6.0
+	github.com/prometheus/client_golang v1.11.0
 	github.com/stretchr/testify v1.7.0
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/sdk v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
 	golang.org/x/lint v0.0.0-20210508222113-6edffad5e616
 	golang.org/x/tools v0.0.0-20200815165600-90abf76919f3 // indirect
 )
@@ -0,0 +1,106 @@
+package crdtex
+
+import (
+	"context"
+	"encoding/json"
+)
+
+// sizedEntry caches the encoded size of an entry
+type sizedEntry struct {
+	entry Entry
+	size  int
+}
+
+// stateToSend returns the state sent to addr: with delta gossip, only the entries
+// newer than the ones acknowledged by addr in the last successful exchange
+func (s *coreService) stateToSend(addr string) State {
+	if !s.options.deltaGossip {
+		return s.getState()
+	}
+
+	// the encoded sizes are only computed for the metrics
+	measure := s.options.metricsEnabled()
+
+	acked := s.peerStates[addr]
+	delta := State{}
+	sentBytes := 0
+	savedBytes := 0
+	s.state.each(func(key string, e Entry) {
+		if ackedEntry, ok := acked[key]; ok && !entryLess(ackedEntry, e) {
+			if measure {
+				savedBytes += s.entrySize(key, e)
+			}
+			return
+		}
+		delta[key] = e
+		if measure {
+			sentBytes += s.entrySize(key, e)
+		}
+	})
+	if measure {
+		s.pruneEntrySizes()
+		s.options.metrics.GossipSent(addr, sentBytes, savedBytes)
+	}
+	return delta
+}
+
+// entrySize returns the size of the JSON encoding of the entry with key
+func (s *coreService) entrySize(key string, e Entry) int {
+	cached, ok := s.entrySizes[key]
+	if ok && entryEqual(cached.entry, e) {
+		return cached.size
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return 0
+	}
+	size := len(key) + len(data)
+	s.entrySizes[key] = sizedEntry{entry: e, size: size}
+	return size
+}
+
+// pruneEntrySizes removes the cached sizes of the keys no longer in the state.
+// The sizes of all the entries have just been cached, so there are none when the sizes match
+func (s *coreService) pruneEntrySizes() {
+	if len(s.entrySizes) <= s.state.len() {
+		return
+	}
+	for key := range s.entrySizes {
+		if _, ok := s.state.get(key); !ok {
+			delete(s.entrySizes, key)
+		}
+	}
+}
+
+// ackPeerState records the state returned by addr as acknowledged.
+// When addr no longer has the entries it acknowledged before, e.g. after a restart,
+// the full state is sent again
+func (s *coreService) ackPeerState(ctx context.Context, addr string, state State) {
+	if !s.options.deltaGossip {
+		return
+	}
+	previous, acked := s.peerStates[addr]
+	if acked && !stateCovers(state, previous) {
+		s.options.logger.Info("delta gossip mismatch, sending full state", "addr", addr)
+		delete(s.peerStates, addr)
+		s.callUpdateRemote(ctx, addr)
+		return
+	}
+	s.peerStates[addr] = state
+}
+
+// forgetPeerState makes the next exchange with addr send the full state
+func (s *coreService) forgetPeerState(addr string) {
+	delete(s.peerStates, addr)
+}
+
+// stateCovers reports whether every entry of b is included in a
+func stateCovers(a, b State) bool {
+	for key, e := range b {
+		current, ok := a[key]
+		if !ok || entryLess(current, e) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,300 @@
+package crdtex
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"sort"
+	"testing"
+	"time"
+)
+
+type gossipRecorder struct {
+	noopMetrics
+	sent  []int
+	saved []int
+}
+
+func (m *gossipRecorder) GossipSent(_ string, sentBytes int, savedBytes int) {
+	m.sent = append(m.sent, sentBytes)
+	m.saved = append(m.saved, savedBytes)
+}
+
+func lastUpdateRemoteState(methods *callbacksMock) State {
+	calls := methods.updateRemoteCalls()
+	return calls[len(calls)-1].State
+}
+
+func TestCoreService_DeltaGossip__Send_Only_Changed_Entries(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	metrics := &gossipRecorder{}
+	s := newTestCoreService(methods, WithDeltaGossip(), WithMetrics(metrics))
+
+	s.init(context.Background())
+	assert.Equal(t, State{
+		"self-addr": {Term: 1, Timestamp: 100, Version: 1},
+	}, lastUpdateRemoteState(methods))
+
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"self-addr":     {Term: 1, Timestamp: 100, Version: 1},
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+			"remote-addr-2": {Term: 1, Timestamp: 90, Version: 3},
+		},
+	})
+	s.updateWithState(State{
+		"remote-addr-2": {Term: 1, Timestamp: 90, Version: 4},
+	})
+
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 2, len(methods.updateRemoteCalls()))
+	assert.Equal(t, State{
+		"self-addr":     {Term: 1, Timestamp: 100, Version: 2},
+		"remote-addr-2": {Term: 1, Timestamp: 90, Version: 4},
+	}, lastUpdateRemoteState(methods))
+
+	assert.Equal(t, 2, len(metrics.sent))
+	assert.Equal(t, 0, metrics.saved[0])
+	assert.Equal(t, s.entrySize("remote-addr-1", s.state.entry("remote-addr-1")), metrics.saved[1])
+}
+
+func TestCoreService_DeltaGossip__Entry_Sizes(t *testing.T) {
+	t.Parallel()
+
+	updated := State{
+		"self-addr":     {Term: 1, Timestamp: 100, Version: 1},
+		"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+	}
+
+	// not computed without metrics
+	s := newTestCoreService(newCallbacksMock(), WithDeltaGossip(), WithMetrics(noopMetrics{}))
+	s.init(context.Background())
+	s.updateWithState(updated)
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 0, len(s.entrySizes))
+
+	s = newTestCoreService(newCallbacksMock(), WithDeltaGossip(), WithMetrics(&gossipRecorder{}))
+	s.init(context.Background())
+	s.updateWithState(updated)
+	s.entrySizes["removed-addr"] = sizedEntry{size: 10}
+	s.handleSyncTimerExpired(context.Background())
+
+	var keys []string
+	for key := range s.entrySizes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	assert.Equal(t, []string{"remote-addr-1", "self-addr"}, keys)
+}
+
+func TestCoreService_DeltaGossip__Full_State_After_Error(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods, WithDeltaGossip(), WithMetrics(noopMetrics{}))
+
+	s.init(context.Background())
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"self-addr":     {Term: 1, Timestamp: 100, Version: 1},
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+		},
+	})
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		err:  context.DeadlineExceeded,
+	})
+
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, State{
+		"self-addr":     {Term: 1, Timestamp: 100, Version: 2},
+		"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+	}, lastUpdateRemoteState(methods))
+}
+
+func TestCoreService_DeltaGossip__Mismatch_Fallback_To_Full_State(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods, WithDeltaGossip(), WithMetrics(noopMetrics{}))
+
+	s.init(context.Background())
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"self-addr":     {Term: 1, Timestamp: 100, Version: 1},
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+			"remote-addr-2": {Term: 1, Timestamp: 90, Version: 1},
+		},
+	})
+
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 2, len(methods.updateRemoteCalls()))
+	assert.Equal(t, State{
+		"self-addr": {Term: 1, Timestamp: 100, Version: 2},
+	}, lastUpdateRemoteState(methods))
+
+	// remote-addr-1 restarted and lost remote-addr-2
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"self-addr":     {Term: 1, Timestamp: 100, Version: 2},
+			"remote-addr-1": {Term: 1, Timestamp: 120, Version: 1},
+		},
+	})
+	assert.Equal(t, 3, len(methods.updateRemoteCalls()))
+	assert.Equal(t, State{
+		"self-addr":     {Term: 1, Timestamp: 100, Version: 2},
+		"remote-addr-1": {Term: 1, Timestamp: 120, Version: 1},
+		"remote-addr-2": {Term: 1, Timestamp: 90, Version: 1},
+	}, lastUpdateRemoteState(methods))
+	_, acked := s.peerStates["remote-addr-1"]
+	assert.False(t, acked)
+}
+
+func TestCoreService_DeltaGossip__Disabled_Sends_Full_State(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	metrics := &gossipRecorder{}
+	s := newCoreService(methods, nodeID{timestamp: 100, addr: "self-addr"},
+		computeOptions(AddRemoteAddress("remote-addr-1"), WithMetrics(metrics)),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+
+	s.init(context.Background())
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		state: State{
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 1},
+		},
+	})
+	s.handleSyncTimerExpired(context.Background())
+
+	assert.Equal(t, s.getState(), lastUpdateRemoteState(methods))
+	assert.Equal(t, 0, len(metrics.sent))
+}
+
+// clusterView is the part of the state of a node expected to converge
+type clusterView struct {
+	members  []string
+	kv       map[string]string
+	requests uint64
+}
+
+func runGossipSimulation(t *testing.T, options ...Option) []clusterView {
+	net := newMemoryNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := []string{"node-1", "node-2", "node-3", "node-4", "node-5"}
+	var runners []*Runner
+	for i, addr := range addrs {
+		opts := append([]Option{
+			AddRemoteAddress(addrs[(i+1)%len(addrs)]),
+			WithSyncDuration(10 * time.Millisecond),
+			WithExpireDuration(5 * time.Second),
+			WithCRDT("requests", NewGCounter()),
+		}, options...)
+		r := net.newRunner(addr, opts...)
+		runners = append(runners, r)
+		assert.Equal(t, nil, r.Start(ctx))
+	}
+
+	for i, r := range runners {
+		for j := 0; j < 10; j++ {
+			assert.Equal(t, nil, r.Set(ctx, fmt.Sprintf("key-%d", j), fmt.Sprintf("node-%d", i)))
+			assert.Equal(t, nil, r.IncrementGCounter(ctx, "requests", 1))
+		}
+		assert.Equal(t, nil, r.Delete(ctx, fmt.Sprintf("key-%d", i)))
+	}
+
+	views := make([]clusterView, len(runners))
+	assert.Eventually(t, func() bool {
+		for i, r := range runners {
+			views[i] = readClusterView(ctx, r)
+		}
+		for _, v := range views[1:] {
+			if !assert.ObjectsAreEqual(views[0], v) {
+				return false
+			}
+		}
+		return len(views[0].members) == len(addrs) && views[0].requests == 50
+	}, 5*time.Second, 5*time.Millisecond)
+	return views
+}
+
+func readClusterView(ctx context.Context, r *Runner) clusterView {
+	var view clusterView
+	_ = r.core.runAction(ctx, func(s *coreService, _ context.Context) {
+		s.state.each(func(key string, e Entry) {
+			if !e.OutOfSync {
+				view.members = append(view.members, key)
+			}
+		})
+		sort.Strings(view.members)
+		view.kv = s.kv
+		view.requests = s.crdts["requests"].value.(GCounter).Value()
+	})
+	return view
+}
+
+func TestRunner_DeltaGossip__Converge_Like_Full_State(t *testing.T) {
+	t.Parallel()
+
+	full := runGossipSimulation(t)
+	delta := runGossipSimulation(t, WithDeltaGossip())
+
+	assert.Equal(t, full[0], delta[0])
+	assert.Equal(t, uint64(50), delta[0].requests)
+	assert.Equal(t, 9, len(delta[0].kv))
+}
+
+func TestRunner_DeltaGossip__Converge_After_Restart(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := []string{"node-1", "node-2", "node-3"}
+	newNode := func(i int) (*Runner, context.CancelFunc) {
+		r := net.newRunner(addrs[i],
+			AddRemoteAddress(addrs[(i+1)%len(addrs)]),
+			WithSyncDuration(10*time.Millisecond),
+			WithExpireDuration(5*time.Second),
+			WithDeltaGossip(),
+		)
+		nodeCtx, nodeCancel := context.WithCancel(ctx)
+		assert.Equal(t, nil, r.Start(nodeCtx))
+		return r, nodeCancel
+	}
+
+	var runners []*Runner
+	var cancels []context.CancelFunc
+	for i := range addrs {
+		r, nodeCancel := newNode(i)
+		runners = append(runners, r)
+		cancels = append(cancels, nodeCancel)
+	}
+	assert.Equal(t, nil, runners[0].Set(ctx, "written-before", "node-1"))
+	assert.Eventually(t, func() bool {
+		value, _, err := runners[2].Get(ctx, "written-before")
+		return err == nil && value == "node-1"
+	}, 5*time.Second, 5*time.Millisecond)
+
+	// node-2 restarts with an empty state, node-1 only sends it deltas until the mismatch
+	cancels[1]()
+	runners[1], cancels[1] = newNode(1)
+	defer cancels[1]()
+
+	assert.Eventually(t, func() bool {
+		value, _, err := runners[1].Get(ctx, "written-before")
+		return err == nil && value == "node-1"
+	}, 5*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,60 @@
+package crdtex
+
+import (
+	"sync"
+	"time"
+)
+
+// hlcLogicalBits is the number of low bits of a HLC timestamp used by the logical counter
+const hlcLogicalBits = 16
+
+// HLC is a hybrid logical clock. Its timestamps are the physical time in milliseconds
+// shifted left by 16 bits plus a logical counter, so they stay close to the wall clock
+// while being strictly greater than any timestamp generated or observed before.
+// All nodes of a cluster must use a HLC for their timestamps to be comparable
+type HLC struct {
+	mut      sync.Mutex
+	physical func() time.Time
+	last     uint64
+}
+
+// NewHLC creates a HLC using time.Now as the physical clock
+func NewHLC() *HLC {
+	return &HLC{
+		physical: time.Now,
+	}
+}
+
+// HLCTimestamp returns the HLC timestamp of t with a zero logical counter
+func HLCTimestamp(t time.Time) uint64 {
+	return uint64(t.UnixNano()/int64(time.Millisecond)) << hlcLogicalBits
+}
+
+// HLCTime returns the physical time of a HLC timestamp
+func HLCTime(ts uint64) time.Time {
+	ms := int64(ts >> hlcLogicalBits)
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
+// Now returns a new timestamp greater than all timestamps generated or observed before
+func (c *HLC) Now() uint64 {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	ts := HLCTimestamp(c.physical())
+	if ts <= c.last {
+		ts = c.last + 1
+	}
+	c.last = ts
+	return ts
+}
+
+// Update observes a timestamp received from another node
+func (c *HLC) Update(remote uint64) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if remote > c.last {
+		c.last = remote
+	}
+}
@@ -0,0 +1,139 @@
+package crdtex
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func newTestHLC(now *time.Time) *HLC {
+	c := NewHLC()
+	c.physical = func() time.Time { return *now }
+	return c
+}
+
+func TestHLC_Now(t *testing.T) {
+	t.Parallel()
+
+	now := mustParse("2021-06-05T10:20:00Z")
+	c := newTestHLC(&now)
+
+	first := c.Now()
+	assert.Equal(t, HLCTimestamp(now), first)
+	assert.Equal(t, now, HLCTime(first).UTC())
+
+	second := c.Now()
+	assert.Equal(t, first+1, second)
+
+	// physical clock goes backwards
+	now = now.Add(-time.Minute)
+	third := c.Now()
+	assert.Equal(t, second+1, third)
+
+	now = now.Add(2 * time.Minute)
+	assert.Equal(t, HLCTimestamp(now), c.Now())
+}
+
+func TestHLC_Update(t *testing.T) {
+	t.Parallel()
+
+	now := mustParse("2021-06-05T10:20:00Z")
+	c := newTestHLC(&now)
+
+	remote := HLCTimestamp(now.Add(time.Minute)) + 5
+	c.Update(remote)
+	assert.Equal(t, remote+1, c.Now())
+
+	c.Update(HLCTimestamp(now))
+	assert.Equal(t, remote+2, c.Now())
+}
+
+func TestCoreService_HLC__Seniority_And_Versions_After_Observed(t *testing.T) {
+	t.Parallel()
+
+	realNow := mustParse("2021-06-05T10:20:00Z")
+	remoteClock := newTestHLC(&realNow)
+	remoteEntry := Entry{Term: 1, Timestamp: remoteClock.Now(), Version: remoteClock.Now()}
+
+	// the clock of this node is one minute behind, it joins after the remote node
+	skewedNow := realNow.Add(-time.Minute)
+	s := newTestCoreService(newCallbacksMock(), WithHLC(newTestHLC(&skewedNow)))
+	s.getNow = func() time.Time { return skewedNow }
+	s.init(context.Background())
+
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr:   "remote-addr-1",
+		state:  State{"remote-addr-1": remoteEntry},
+		sentAt: skewedNow,
+	})
+
+	// the election timestamp is taken after observing the cluster
+	assert.Equal(t, "remote-addr-1", s.leaderAddr)
+	assert.True(t, s.self.timestamp > remoteEntry.Timestamp)
+	assert.True(t, s.state.entry("self-addr").Timestamp > remoteEntry.Timestamp)
+
+	// version bumps are ordered after the observed entries
+	s.bumpSelfEntry()
+	assert.True(t, s.state.entry("self-addr").Version > remoteEntry.Version)
+
+	// later exchanges keep the seniority
+	timestamp := s.self.timestamp
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr:   "remote-addr-1",
+		state:  State{"remote-addr-1": remoteEntry},
+		sentAt: skewedNow,
+	})
+	assert.Equal(t, timestamp, s.self.timestamp)
+
+	// stepping down keeps this node younger than every observed node
+	s.stepDown(context.Background())
+	assert.True(t, s.self.timestamp > remoteEntry.Timestamp)
+	assert.Equal(t, "remote-addr-1", s.leaderAddr)
+}
+
+func TestCoreService_HLC__Seniority_Kept_By_Older_Node(t *testing.T) {
+	t.Parallel()
+
+	// this node is the oldest, with a clock one minute behind
+	realNow := mustParse("2021-06-05T10:20:00Z")
+	skewedNow := realNow.Add(-time.Minute)
+	s := newTestCoreService(newCallbacksMock(), WithHLC(newTestHLC(&skewedNow)))
+	s.getNow = func() time.Time { return skewedNow }
+	s.init(context.Background())
+
+	// its first exchange fails, the remote node is not started yet
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr: "remote-addr-1",
+		err:  errors.New("connection refused"),
+	})
+	timestamp := s.self.timestamp
+
+	// the remote node joins later and observes this node before taking its seniority
+	remoteNow := realNow.Add(time.Second)
+	remoteClock := newTestHLC(&remoteNow)
+	remoteClock.Update(s.state.entry("self-addr").Version)
+	remoteEntry := Entry{Term: 1, Timestamp: remoteClock.Now(), Version: remoteClock.Now()}
+
+	s.handleUpdateResult(context.Background(), updateResult{
+		addr:   "remote-addr-1",
+		state:  State{"remote-addr-1": remoteEntry},
+		sentAt: skewedNow,
+	})
+	assert.Equal(t, timestamp, s.self.timestamp)
+	assert.Equal(t, "self-addr", s.leaderAddr)
+}
+
+func TestCoreService_StepDown__Wall_Clock_Skew(t *testing.T) {
+	t.Parallel()
+
+	// without a HLC, a node with a clock behind stays the oldest after stepping down
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods)
+	startTwoNodes(s)
+	s.getNow = func() time.Time { return time.Unix(0, 150) }
+
+	s.stepDown(context.Background())
+	assert.Equal(t, "self-addr", s.leaderAddr)
+}
@@ -0,0 +1,348 @@
+// Package httptransport implements the transport between crdtex nodes over HTTP
+package httptransport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/crdtex"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Paths of the endpoints served by the handler
+const (
+	PathUpdate       = "/crdtex/update"
+	PathAntiEntropy  = "/crdtex/anti-entropy"
+	PathPing         = "/crdtex/ping"
+	PathPingIndirect = "/crdtex/ping-indirect"
+	PathDebug        = "/crdtex/debug"
+	PathStepDown     = "/crdtex/step-down"
+	PathLeave        = "/crdtex/leave"
+)
+
+// Client calls the endpoints of remote nodes,
+// it implements the UpdateRemote method of crdtex.Interface, crdtex.Prober and crdtex.AntiEntropy
+type Client struct {
+	httpClient *http.Client
+}
+
+// NewClient creates a Client, addresses are host:port or base URLs
+func NewClient(httpClient *http.Client) *Client {
+	return &Client{
+		httpClient: httpClient,
+	}
+}
+
+// baseURL returns the URL of addr, a host:port address must not contain a path, a query or user info
+func baseURL(addr string) (string, error) {
+	if strings.Contains(addr, "://") {
+		return strings.TrimSuffix(addr, "/"), nil
+	}
+	u, err := url.Parse("http://" + addr)
+	if err != nil || u.Host != addr {
+		return "", fmt.Errorf("httptransport: invalid address %q", addr)
+	}
+	return "http://" + addr, nil
+}
+
+func (c *Client) do(ctx context.Context, method string, addr string, path string, body []byte) ([]byte, error) {
+	base, err := baseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, base+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("httptransport: %s %s returned status %d: %s",
+			method, req.URL, resp.StatusCode, strings.TrimSpace(string(data)))
+	}
+	return data, nil
+}
+
+// UpdateRemote sends state to addr and returns the state of addr after merged
+func (c *Client) UpdateRemote(ctx context.Context, addr string, state crdtex.State) (crdtex.State, error) {
+	body, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.do(ctx, http.MethodPost, addr, PathUpdate, body)
+	if err != nil {
+		return nil, err
+	}
+
+	var result crdtex.State
+	if err := json.Unmarshal(data, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AntiEntropy sends an anti-entropy request to addr
+func (c *Client) AntiEntropy(
+	ctx context.Context, addr string, req crdtex.AntiEntropyRequest,
+) (crdtex.AntiEntropyResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return crdtex.AntiEntropyResponse{}, err
+	}
+	data, err := c.do(ctx, http.MethodPost, addr, PathAntiEntropy, body)
+	if err != nil {
+		return crdtex.AntiEntropyResponse{}, err
+	}
+
+	var resp crdtex.AntiEntropyResponse
+	if err := json.Unmarshal(data, &resp); err != nil {
+		return crdtex.AntiEntropyResponse{}, err
+	}
+	return resp, nil
+}
+
+// Ping checks that addr is alive
+func (c *Client) Ping(ctx context.Context, addr string) error {
+	_, err := c.do(ctx, http.MethodGet, addr, PathPing, nil)
+	return err
+}
+
+// PingIndirect asks via to ping addr
+func (c *Client) PingIndirect(ctx context.Context, via string, addr string) error {
+	path := PathPingIndirect + "?addr=" + url.QueryEscape(addr)
+	_, err := c.do(ctx, http.MethodPost, via, path, nil)
+	return err
+}
+
+// DebugInfo returns the cluster view of addr
+func (c *Client) DebugInfo(ctx context.Context, addr string) (crdtex.DebugInfo, error) {
+	data, err := c.do(ctx, http.MethodGet, addr, PathDebug, nil)
+	if err != nil {
+		return crdtex.DebugInfo{}, err
+	}
+
+	var info crdtex.DebugInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return crdtex.DebugInfo{}, err
+	}
+	return info, nil
+}
+
+// StepDown asks addr to step down
+func (c *Client) StepDown(ctx context.Context, addr string) error {
+	_, err := c.do(ctx, http.MethodPost, addr, PathStepDown, nil)
+	return err
+}
+
+// Leave asks addr to leave the cluster
+func (c *Client) Leave(ctx context.Context, addr string) error {
+	_, err := c.do(ctx, http.MethodPost, addr, PathLeave, nil)
+	return err
+}
+
+// Transport implements crdtex.Interface, crdtex.Prober and crdtex.AntiEntropy with a Client
+type Transport struct {
+	*Client
+
+	// StartFunc is called when the node becomes the leader, with a context
+	// cancelled when the node is no longer the leader. When nil, Start waits for the cancellation
+	StartFunc func(ctx context.Context)
+}
+
+var _ crdtex.Interface = Transport{}
+var _ crdtex.Prober = Transport{}
+var _ crdtex.AntiEntropy = Transport{}
+
+// Start calls StartFunc
+func (t Transport) Start(ctx context.Context) {
+	if t.StartFunc == nil {
+		<-ctx.Done()
+		return
+	}
+	t.StartFunc(ctx)
+}
+
+// HandlerOption configures the handler returned by NewHandler
+type HandlerOption func(opts *handlerOptions)
+
+type handlerOptions struct {
+	authorize func(req *http.Request) bool
+}
+
+// WithAdminAuth serves the step-down and leave endpoints only for the requests
+// accepted by authorize, the others are answered with 403 Forbidden
+func WithAdminAuth(authorize func(req *http.Request) bool) HandlerOption {
+	return func(opts *handlerOptions) {
+		opts.authorize = authorize
+	}
+}
+
+type handler struct {
+	runner  *crdtex.Runner
+	client  *Client
+	debug   http.Handler
+	options handlerOptions
+}
+
+// NewHandler returns a http.Handler serving the endpoints of runner,
+// client is used to ping other nodes on behalf of the callers.
+// Indirect pings are only sent to the host:port addresses of the members and of the remote addresses
+func NewHandler(runner *crdtex.Runner, client *Client, options ...HandlerOption) http.Handler {
+	h := &handler{
+		runner: runner,
+		client: client,
+		debug:  runner.DebugHandler(),
+	}
+	for _, opt := range options {
+		opt(&h.options)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(PathUpdate, h.handleUpdate)
+	mux.HandleFunc(PathAntiEntropy, h.handleAntiEntropy)
+	mux.HandleFunc(PathPing, h.handlePing)
+	mux.HandleFunc(PathPingIndirect, h.handlePingIndirect)
+	mux.Handle(PathDebug, h.debug)
+	mux.HandleFunc(PathStepDown, h.handleStepDown)
+	mux.HandleFunc(PathLeave, h.handleLeave)
+	return mux
+}
+
+func allowMethod(w http.ResponseWriter, req *http.Request, method string) bool {
+	if req.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (h *handler) handleUpdate(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	var state crdtex.State
+	if err := json.NewDecoder(io.LimitReader(req.Body, 64<<20)).Decode(&state); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.runner.UpdateState(req.Context(), state)
+	if err != nil {
+		http.Error(w, err.Error(), updateErrorStatus(err))
+		return
+	}
+	writeJSON(w, result)
+}
+
+func (h *handler) handleAntiEntropy(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	var aeReq crdtex.AntiEntropyRequest
+	if err := json.NewDecoder(io.LimitReader(req.Body, 64<<20)).Decode(&aeReq); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.runner.AntiEntropy(req.Context(), aeReq)
+	if err != nil {
+		http.Error(w, err.Error(), updateErrorStatus(err))
+		return
+	}
+	writeJSON(w, resp)
+}
+
+// updateErrorStatus returns 429 when the runner is overloaded, so that the caller retries later
+func updateErrorStatus(err error) int {
+	if errors.Is(err, crdtex.ErrOverloaded) {
+		return http.StatusTooManyRequests
+	}
+	return http.StatusServiceUnavailable
+}
+
+func (h *handler) handlePing(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodGet) {
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) handlePingIndirect(w http.ResponseWriter, req *http.Request) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+
+	addr := req.URL.Query().Get("addr")
+	if addr == "" {
+		http.Error(w, "missing addr", http.StatusBadRequest)
+		return
+	}
+	if strings.Contains(addr, "://") {
+		http.Error(w, "invalid addr", http.StatusBadRequest)
+		return
+	}
+	member, err := h.runner.IsMember(req.Context(), addr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if !member {
+		http.Error(w, "unknown addr", http.StatusBadRequest)
+		return
+	}
+	// the error is not returned, it could reveal the network of this node
+	if err := h.client.Ping(req.Context(), addr); err != nil {
+		http.Error(w, "ping failed", http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) handleAction(w http.ResponseWriter, req *http.Request, action func(ctx context.Context) error) {
+	if !allowMethod(w, req, http.MethodPost) {
+		return
+	}
+	if h.options.authorize != nil && !h.options.authorize(req) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if err := action(req.Context()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *handler) handleStepDown(w http.ResponseWriter, req *http.Request) {
+	h.handleAction(w, req, h.runner.StepDown)
+}
+
+func (h *handler) handleLeave(w http.ResponseWriter, req *http.Request) {
+	h.handleAction(w, req, h.runner.Leave)
+}
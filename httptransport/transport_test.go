@@ -0,0 +1,280 @@
+package httptransport
+
+import (
+	"context"
+	"fmt"
+	"github.com/QuangTung97/crdtex"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testNode struct {
+	addr   string
+	runner *crdtex.Runner
+	server *httptest.Server
+}
+
+func startNodes(t *testing.T, n int, extra ...crdtex.Option) []*testNode {
+	client := NewClient(&http.Client{Timeout: time.Second})
+
+	nodes := make([]*testNode, 0, n)
+	for i := 0; i < n; i++ {
+		server := httptest.NewUnstartedServer(nil)
+		nodes = append(nodes, &testNode{
+			addr:   server.Listener.Addr().String(),
+			server: server,
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	for _, node := range nodes {
+		options := []crdtex.Option{
+			crdtex.WithSyncDuration(20 * time.Millisecond),
+			crdtex.WithExpireDuration(2 * time.Second),
+			crdtex.WithProbing(1, time.Second),
+		}
+		options = append(options, extra...)
+		for _, other := range nodes {
+			if other != node {
+				options = append(options, crdtex.AddRemoteAddress(other.addr))
+			}
+		}
+
+		node.runner = crdtex.NewRunner(Transport{Client: client}, node.addr, options...)
+		node.server.Config.Handler = NewHandler(node.runner, client)
+		node.server.Start()
+		t.Cleanup(node.server.Close)
+
+		assert.Equal(t, nil, node.runner.Start(ctx))
+	}
+	return nodes
+}
+
+func memberAddrs(info crdtex.DebugInfo) []string {
+	var addrs []string
+	for _, m := range info.Members {
+		addrs = append(addrs, m.Addr)
+	}
+	return addrs
+}
+
+func TestHTTPTransport_Converge(t *testing.T) {
+	t.Parallel()
+
+	nodes := startNodes(t, 3)
+	client := NewClient(http.DefaultClient)
+
+	expected := []string{nodes[0].addr, nodes[1].addr, nodes[2].addr}
+	sort.Strings(expected)
+
+	for _, node := range nodes {
+		assert.Eventually(t, func() bool {
+			info, err := client.DebugInfo(context.Background(), node.addr)
+			if err != nil {
+				return false
+			}
+			return assert.ObjectsAreEqual(expected, memberAddrs(info)) && info.Leader == nodes[0].addr
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestHTTPTransport_AntiEntropy(t *testing.T) {
+	t.Parallel()
+
+	nodes := startNodes(t, 3, crdtex.WithAntiEntropy(1))
+	client := NewClient(http.DefaultClient)
+	ctx := context.Background()
+
+	assert.Equal(t, nil, nodes[0].runner.Set(ctx, "key", "value"))
+	assert.Eventually(t, func() bool {
+		value, _, err := nodes[2].runner.Get(ctx, "key")
+		return err == nil && value == "value"
+	}, 5*time.Second, 10*time.Millisecond)
+
+	resp, err := client.AntiEntropy(ctx, nodes[0].addr, crdtex.AntiEntropyRequest{
+		Nodes: []crdtex.MerkleNode{{Level: 0, Index: 0, Hash: 0}},
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 16, len(resp.Nodes))
+	assert.Equal(t, 1, resp.Nodes[0].Level)
+}
+
+func TestClient_Ping(t *testing.T) {
+	t.Parallel()
+
+	nodes := startNodes(t, 2)
+	client := NewClient(http.DefaultClient)
+	ctx := context.Background()
+
+	assert.Equal(t, nil, client.Ping(ctx, nodes[0].addr))
+	assert.Equal(t, nil, client.PingIndirect(ctx, nodes[0].addr, nodes[1].addr))
+
+	nodes[1].server.Close()
+	assert.Error(t, client.PingIndirect(ctx, nodes[0].addr, nodes[1].addr))
+}
+
+func TestHandler_PingIndirect__Only_Members(t *testing.T) {
+	t.Parallel()
+
+	nodes := startNodes(t, 2)
+	client := NewClient(http.DefaultClient)
+	ctx := context.Background()
+
+	outsider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t.Errorf("unexpected request %s", req.URL)
+	}))
+	defer outsider.Close()
+
+	err := client.PingIndirect(ctx, nodes[0].addr, outsider.Listener.Addr().String())
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "status 400: unknown addr"))
+
+	err = client.PingIndirect(ctx, nodes[0].addr, "http://"+nodes[1].addr)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "status 400: invalid addr"))
+
+	nodes[1].server.Close()
+	err = client.PingIndirect(ctx, nodes[0].addr, nodes[1].addr)
+	assert.Error(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "status 502: ping failed"))
+}
+
+func TestClient_Invalid_Address(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(http.DefaultClient)
+	for _, addr := range []string{"host:80/path", "user@host:80", "host:80?q=1"} {
+		err := client.Ping(context.Background(), addr)
+		assert.Equal(t, fmt.Sprintf("httptransport: invalid address %q", addr), err.Error())
+	}
+}
+
+func TestHandler_Admin_Auth(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(http.DefaultClient)
+	runner := crdtex.NewRunner(Transport{Client: client}, "self")
+	server := httptest.NewServer(NewHandler(runner, client, WithAdminAuth(func(req *http.Request) bool {
+		return req.Header.Get("Authorization") == "Bearer secret"
+	})))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	assert.Equal(t, nil, runner.Start(ctx))
+
+	err := client.StepDown(ctx, server.URL)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "status 403: forbidden"))
+	err = client.Leave(ctx, server.URL)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "status 403: forbidden"))
+
+	req, err := http.NewRequest(http.MethodPost, server.URL+PathLeave, nil)
+	assert.Equal(t, nil, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Equal(t, nil, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	info, err := runner.DebugInfo(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, info.Left)
+}
+
+func TestClient_StepDown_And_Leave(t *testing.T) {
+	t.Parallel()
+
+	nodes := startNodes(t, 2)
+	client := NewClient(http.DefaultClient)
+	ctx := context.Background()
+
+	assert.Eventually(t, func() bool {
+		info, err := client.DebugInfo(ctx, nodes[1].addr)
+		return err == nil && info.Leader == nodes[0].addr
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, nil, client.StepDown(ctx, nodes[0].addr))
+	assert.Eventually(t, func() bool {
+		info, err := client.DebugInfo(ctx, nodes[0].addr)
+		return err == nil && info.Leader == nodes[1].addr
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, nil, client.Leave(ctx, nodes[1].addr))
+	info, err := client.DebugInfo(ctx, nodes[1].addr)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, info.Left)
+}
+
+func TestHandler_Method_Not_Allowed(t *testing.T) {
+	t.Parallel()
+
+	nodes := startNodes(t, 1)
+
+	resp, err := http.Get("http://" + nodes[0].addr + PathLeave)
+	assert.Equal(t, nil, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	assert.Equal(t, http.MethodPost, resp.Header.Get("Allow"))
+}
+
+// stalledStore blocks the core in Load until release is closed
+type stalledStore struct {
+	release chan struct{}
+}
+
+func (s stalledStore) Load() (crdtex.Snapshot, bool, error) {
+	<-s.release
+	return crdtex.Snapshot{}, false, nil
+}
+
+func (s stalledStore) Save(crdtex.Snapshot) error {
+	return nil
+}
+
+func TestHandler_Update_Overloaded(t *testing.T) {
+	t.Parallel()
+
+	client := NewClient(http.DefaultClient)
+	store := stalledStore{release: make(chan struct{})}
+	runner := crdtex.NewRunner(Transport{Client: client}, "stalled",
+		crdtex.WithFailFastUpdates(),
+		crdtex.WithStore(store, 0),
+	)
+	server := httptest.NewServer(NewHandler(runner, client))
+	defer server.Close()
+
+	// the runner is stalled in Load, the queued updates are not handled
+	runCtx, stop := context.WithCancel(context.Background())
+	assert.Equal(t, nil, runner.Start(runCtx))
+	defer func() {
+		stop()
+		close(store.release)
+		<-runner.Done()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	var wg sync.WaitGroup
+	for i := 0; i < 256; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = runner.UpdateState(ctx, crdtex.State{})
+		}()
+	}
+	wg.Wait()
+
+	_, err := client.UpdateRemote(context.Background(), server.URL, crdtex.State{})
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "status 429: crdtex: runner is overloaded"))
+}
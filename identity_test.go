@@ -0,0 +1,271 @@
+package crdtex
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestState_AddrOf_And_KeyOf(t *testing.T) {
+	t.Parallel()
+
+	s := State{
+		"addr-1": {Term: 1},
+		"id-2":   {Term: 1, Addr: "addr-2"},
+	}
+
+	assert.Equal(t, "addr-1", addrOf(s, "addr-1"))
+	assert.Equal(t, "addr-2", addrOf(s, "id-2"))
+	assert.Equal(t, "unknown", addrOf(s, "unknown"))
+
+	key, ok := keyOf(s, "addr-1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "addr-1", key)
+
+	key, ok = keyOf(s, "addr-2")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "id-2", key)
+
+	_, ok = keyOf(s, "id-2")
+	assert.Equal(t, false, ok)
+}
+
+func TestKeyOf__Reused_Address(t *testing.T) {
+	t.Parallel()
+
+	s := State{
+		"id-1":   {Term: 1, Timestamp: 1, Addr: "addr-1", OutOfSync: true},
+		"id-2":   {Term: 1, Timestamp: 2, Addr: "addr-1"},
+		"id-3":   {Term: 1, Timestamp: 5, Addr: "addr-2", OutOfSync: true},
+		"id-4":   {Term: 1, Timestamp: 3, Addr: "addr-2", OutOfSync: true},
+		"addr-3": {Term: 1, Timestamp: 6, OutOfSync: true},
+		"id-5":   {Term: 1, Timestamp: 4, Addr: "addr-3"},
+	}
+
+	for _, reader := range []entryReader{s, newStateTrie(s)} {
+		// the entry in sync
+		key, ok := keyOf(reader, "addr-1")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "id-2", key)
+
+		// the newest entry out of sync
+		key, ok = keyOf(reader, "addr-2")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "id-3", key)
+
+		key, ok = keyOf(reader, "addr-3")
+		assert.Equal(t, true, ok)
+		assert.Equal(t, "id-5", key)
+	}
+}
+
+func TestCoreService_NodeID__Self_Entry_Carries_Address(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods, WithNodeID("self-id"))
+	s.init(context.Background())
+
+	assert.Equal(t, State{
+		"self-id": {Term: 1, Timestamp: 100, Version: 1, Addr: "self-addr"},
+	}, methods.updateRemoteCalls()[0].State)
+
+	s.updateWithState(State{
+		"remote-id-1": {Term: 1, Timestamp: 50, Version: 1, Addr: "remote-addr-1"},
+	})
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, "remote-id-1", s.leader.addr)
+	assert.Equal(t, "remote-addr-1", s.leaderAddr)
+	assert.Equal(t, 2, s.clusterSize())
+}
+
+func TestCoreService_NodeID__Address_Change_Replaces_Entry(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(), WithNodeID("self-id"))
+	s.init(context.Background())
+
+	s.updateWithState(State{
+		"remote-id-1": {Term: 1, Timestamp: 50, Version: 3, Addr: "remote-addr-1"},
+	})
+	s.computeAndStartLeader(context.Background())
+
+	respChan := make(chan string, 1)
+	fetchLeaderRequest{lastLeader: "remote-addr-1", respChan: respChan}.handle(context.Background(), s)
+
+	// restarted on another address with a newer incarnation
+	s.updateWithState(State{
+		"remote-id-1": {Term: 2, Timestamp: 50, Version: 1, Addr: "remote-addr-2"},
+	})
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, 2, s.state.len())
+	assert.Equal(t, "remote-addr-2", s.leaderAddr)
+	assert.Equal(t, "remote-addr-2", <-respChan)
+}
+
+func TestCoreService_NodeID__Probe_Result_By_Address(t *testing.T) {
+	t.Parallel()
+
+	methods := newCallbacksMock()
+	s := newTestCoreService(methods, WithNodeID("self-id"), WithProbing(0, 10*time.Second))
+	s.randIntn = func(n int) int { return 0 }
+	s.init(context.Background())
+	s.updateWithState(State{
+		"remote-id-1": {Term: 1, Timestamp: 50, Version: 1, Addr: "remote-addr-1"},
+	})
+
+	s.probeRandomMember(context.Background())
+	assert.Equal(t, "remote-addr-1", methods.probeCalls()[0].Addr)
+
+	s.handleProbeResult(context.Background(), probeResult{addr: "remote-addr-1", ok: false})
+	assert.Equal(t, true, s.state.entry("remote-id-1").Suspect)
+	assert.Equal(t, mustParse("2021-06-05T10:20:00Z"), s.suspected["remote-id-1"])
+}
+
+func TestCoreService_NodeID__Restore_Keeps_Seniority(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{snapshot: &Snapshot{
+		State: State{
+			"self-id":     {Term: 2, Timestamp: 30, Version: 9, OutOfSync: true, Addr: "old-addr"},
+			"remote-id-1": {Term: 1, Timestamp: 50, Version: 3, Addr: "remote-addr-1"},
+		},
+		Term:    2,
+		Version: 9,
+		SavedAt: mustParse("2021-06-05T10:19:50Z"),
+	}}
+	s := newTestCoreService(newCallbacksMock(),
+		WithNodeID("self-id"),
+		WithStore(store, time.Minute),
+	)
+
+	s.init(context.Background())
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, Entry{Term: 3, Timestamp: 30, Version: 1, Addr: "self-addr"}, s.state.entry("self-id"))
+	assert.Equal(t, "self-id", s.leader.addr)
+	assert.Equal(t, "self-addr", s.leaderAddr)
+
+	// the lingering entry of the previous incarnation is replaced
+	assert.Equal(t, State{
+		"self-id":     {Term: 3, Timestamp: 30, Version: 1, Addr: "self-addr"},
+		"remote-id-1": {Term: 1, Timestamp: 50, Version: 3, Addr: "remote-addr-1"},
+	}, combineStates(State{
+		"self-id": {Term: 2, Timestamp: 30, Version: 9, OutOfSync: true, Addr: "old-addr"},
+	}, s.getState()))
+}
+
+func TestCoreService_NodeID__Adopt_Seniority_Without_Store(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(), WithNodeID("self-id"))
+	s.init(context.Background())
+
+	s.updateWithState(State{
+		"self-id":     {Term: 2, Timestamp: 30, Version: 9, OutOfSync: true, Addr: "old-addr"},
+		"remote-id-1": {Term: 1, Timestamp: 50, Version: 3, Addr: "remote-addr-1"},
+	})
+	s.computeAndStartLeader(context.Background())
+
+	assert.Equal(t, Entry{Term: 3, Timestamp: 30, Version: 2, Addr: "self-addr"}, s.state.entry("self-id"))
+	assert.Equal(t, "self-id", s.leader.addr)
+
+	// not adopted once the entry is bumped
+	s.updateWithState(State{
+		"self-id": {Term: 1, Timestamp: 20, Version: 1},
+	})
+	assert.Equal(t, uint64(30), s.state.entry("self-id").Timestamp)
+}
+
+func TestCoreService_NodeID__Keep_Timestamp_Once_Bumped(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock(), WithNodeID("self-id"))
+	s.init(context.Background())
+	s.handleSyncTimerExpired(context.Background())
+
+	s.updateWithState(State{
+		"self-id": {Term: 1, Timestamp: 30, Version: 9, Addr: "old-addr"},
+	})
+	assert.Equal(t, uint64(100), s.state.entry("self-id").Timestamp)
+}
+
+func TestRunner_NodeID__Restart_On_New_Address(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	options := func(id string, remote string) []Option {
+		return []Option{
+			WithNodeID(id),
+			AddRemoteAddress(remote),
+			WithSyncDuration(10 * time.Millisecond),
+			WithExpireDuration(5 * time.Second),
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := net.newRunner("addr-1", options("id-1", "addr-2")...)
+	assert.Equal(t, nil, first.Start(ctx))
+
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	go net.newRunner("addr-2", options("id-2", "addr-1")...).Run(secondCtx)
+
+	assert.Eventually(t, func() bool {
+		info, err := first.DebugInfo(ctx)
+		return err == nil && len(info.Members) == 2
+	}, 5*time.Second, 5*time.Millisecond)
+	secondCancel()
+
+	go net.newRunner("addr-3", options("id-2", "addr-1")...).Run(ctx)
+
+	assert.Eventually(t, func() bool {
+		info, err := first.DebugInfo(ctx)
+		if err != nil || len(info.Members) != 2 {
+			return false
+		}
+		m := info.Members[1]
+		return m.ID == "id-2" && m.Addr == "addr-3" && !m.OutOfSync
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestRunner_NodeID__Restart_Keeps_Seniority_Without_Store(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx := context.Background()
+	newRunner := func(addr string, id string, remote string) *Runner {
+		r := net.newRunner(addr,
+			WithNodeID(id),
+			AddRemoteAddress(remote),
+			WithSyncDuration(10*time.Millisecond),
+		)
+		assert.Equal(t, nil, r.Start(ctx))
+		return r
+	}
+	leaderIs := func(r *Runner, expected string) func() bool {
+		return func() bool {
+			info, err := r.DebugInfo(ctx)
+			return err == nil && info.Leader == expected
+		}
+	}
+
+	first := newRunner("addr-1", "id-1", "addr-2")
+	assert.Eventually(t, leaderIs(first, "addr-1"), 5*time.Second, 5*time.Millisecond)
+	second := newRunner("addr-2", "id-2", "addr-1")
+	defer func() { _ = second.Stop(ctx) }()
+	assert.Eventually(t, leaderIs(second, "addr-1"), 5*time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, nil, first.Stop(ctx))
+	assert.Eventually(t, leaderIs(second, "addr-2"), 5*time.Second, 5*time.Millisecond)
+
+	restarted := newRunner("addr-3", "id-1", "addr-2")
+	defer func() { _ = restarted.Stop(ctx) }()
+
+	assert.Eventually(t, leaderIs(second, "addr-3"), 5*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, leaderIs(restarted, "addr-3"), 5*time.Second, 5*time.Millisecond)
+}
@@ -0,0 +1,345 @@
+package crdtex
+
+import (
+	"context"
+	"sort"
+)
+
+// Register is a last-writer-wins register of a replicated key,
+// stored in the entry of the node that wrote it
+type Register struct {
+	Value     string `json:",omitempty"`
+	Deleted   bool   `json:",omitempty"`
+	Timestamp uint64
+}
+
+// registerLess orders the registers of the writers a and b,
+// by timestamp then by the key of the writer
+func registerLess(a Register, aWriter string, b Register, bWriter string) bool {
+	if a.Timestamp < b.Timestamp {
+		return true
+	}
+	if a.Timestamp > b.Timestamp {
+		return false
+	}
+
+	if aWriter != bWriter {
+		return aWriter < bWriter
+	}
+	return boolLess(a.Deleted, b.Deleted)
+}
+
+type kvRegister struct {
+	writer   string
+	register Register
+}
+
+// latestRegisters returns the winning register of every key of the state
+func latestRegisters(s entryReader) map[string]kvRegister {
+	result := map[string]kvRegister{}
+	s.each(func(writer string, e Entry) {
+		for key, r := range e.Data {
+			current, existed := result[key]
+			if existed && !registerLess(current.register, current.writer, r, writer) {
+				continue
+			}
+			result[key] = kvRegister{writer: writer, register: r}
+		}
+	})
+	return result
+}
+
+// keyValues returns the values of the keys that are not deleted
+func keyValues(s entryReader) map[string]string {
+	result := map[string]string{}
+	for key, r := range latestRegisters(s) {
+		if !r.register.Deleted {
+			result[key] = r.register.Value
+		}
+	}
+	return result
+}
+
+func stringMapEqual(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// KVChange is a change of a replicated key
+type KVChange struct {
+	Key     string
+	Value   string
+	Deleted bool
+}
+
+type kvView struct {
+	revision uint64
+	values   map[string]string
+}
+
+type fetchKVRequest struct {
+	lastRevision uint64
+	respChan     chan<- kvView
+}
+
+var _ coreRequest = fetchKVRequest{}
+
+func (req fetchKVRequest) handle(_ context.Context, s *coreService) {
+	if req.lastRevision != s.kvRevision {
+		notifyKV(req.respChan, kvView{revision: s.kvRevision, values: s.kv})
+		return
+	}
+	for _, waiter := range s.kvWaitList {
+		if waiter == req.respChan {
+			// still waiting since a cancelled call
+			return
+		}
+	}
+	s.kvWaitList = append(s.kvWaitList, req.respChan)
+}
+
+// notifyKV sends view without blocking, a cancelled watcher is still holding a previous view
+func notifyKV(waiter chan<- kvView, view kvView) {
+	select {
+	case waiter <- view:
+	default:
+	}
+}
+
+// writeTimestamp returns the timestamp of a new register, greater than all previous writes of this node
+func (s *coreService) writeTimestamp() uint64 {
+	var ts uint64
+	if s.options.clock != nil {
+		ts = s.options.clock.Now()
+	} else {
+		ts = uint64(s.getNow().UnixNano())
+	}
+	if ts <= s.lastWrite {
+		ts = s.lastWrite + 1
+	}
+	s.lastWrite = ts
+	return ts
+}
+
+func (s *coreService) writeRegister(key string, r Register) {
+	data := make(map[string]Register, len(s.selfData)+1)
+	for k, v := range s.selfData {
+		data[k] = v
+	}
+	data[key] = r
+	s.selfData = data
+
+	s.bumpSelfEntry()
+	s.refreshKV()
+}
+
+func (s *coreService) setValue(key string, value string) {
+	s.writeRegister(key, Register{Value: value, Timestamp: s.writeTimestamp()})
+}
+
+func (s *coreService) deleteValue(key string) {
+	s.writeRegister(key, Register{Deleted: true, Timestamp: s.writeTimestamp()})
+}
+
+// adoptData merges the registers written by a previous incarnation of this node
+func (s *coreService) adoptData(previous map[string]Register) {
+	var data map[string]Register
+	for key, r := range previous {
+		if r.Timestamp > s.lastWrite {
+			s.lastWrite = r.Timestamp
+		}
+		current, existed := s.selfData[key]
+		if existed && !registerLess(current, s.self.addr, r, s.self.addr) {
+			continue
+		}
+		if data == nil {
+			data = make(map[string]Register, len(s.selfData)+len(previous))
+			for k, v := range s.selfData {
+				data[k] = v
+			}
+		}
+		data[key] = r
+	}
+	if data != nil {
+		s.selfData = data
+	}
+}
+
+// compactSelfData removes the registers of this node superseded by the writes of other nodes.
+// Tombstones are only removed when superseded, so that older values can not come back
+func (s *coreService) compactSelfData() {
+	if len(s.selfData) == 0 {
+		return
+	}
+	latest := latestRegisters(s.state)
+
+	var data map[string]Register
+	for key := range s.selfData {
+		if latest[key].writer == s.self.addr {
+			continue
+		}
+		if data == nil {
+			data = make(map[string]Register, len(s.selfData))
+			for k, v := range s.selfData {
+				data[k] = v
+			}
+		}
+		delete(data, key)
+	}
+	if data == nil {
+		return
+	}
+
+	if len(data) == 0 {
+		data = nil
+	}
+	s.selfData = data
+	s.bumpSelfEntry()
+}
+
+// mergeData compacts the local registers and notifies the key value watchers
+// when the data of the entries changed
+func (s *coreService) mergeData(oldState stateTrie) {
+	changed := false
+	s.state.eachChangedSince(oldState, func(key string, e Entry) {
+		old := oldState.entry(key)
+		if len(e.Data) > 0 || len(old.Data) > 0 {
+			changed = changed || !entryEqual(old, e)
+		}
+	})
+	if !changed {
+		return
+	}
+	s.compactSelfData()
+	s.refreshKV()
+}
+
+// refreshKV recomputes the key values and notifies the watchers if they changed
+func (s *coreService) refreshKV() {
+	values := keyValues(s.state)
+	if stringMapEqual(values, s.kv) {
+		return
+	}
+
+	s.kv = values
+	s.kvRevision++
+	for i, waiter := range s.kvWaitList {
+		notifyKV(waiter, kvView{revision: s.kvRevision, values: values})
+		s.kvWaitList[i] = nil
+	}
+	s.kvWaitList = s.kvWaitList[:0]
+}
+
+// Set sets the value of a replicated key
+func (r *Runner) Set(ctx context.Context, key string, value string) error {
+	return r.runAction(ctx, func(s *coreService, _ context.Context) {
+		s.setValue(key, value)
+	})
+}
+
+// Delete deletes a replicated key
+func (r *Runner) Delete(ctx context.Context, key string) error {
+	return r.runAction(ctx, func(s *coreService, _ context.Context) {
+		s.deleteValue(key)
+	})
+}
+
+// Get returns the value of a replicated key, ok is false if the key does not exist
+func (r *Runner) Get(ctx context.Context, key string) (value string, ok bool, err error) {
+	err = r.runAction(ctx, func(s *coreService, _ context.Context) {
+		value, ok = s.kv[key]
+	})
+	if err != nil {
+		return "", false, err
+	}
+	return value, ok, nil
+}
+
+// KVWatcher watches the changes of the replicated keys
+type KVWatcher struct {
+	core     *coreService
+	ch       chan kvView
+	revision uint64
+	values   map[string]string
+}
+
+// NewKVWatcher creates a watcher of the replicated keys
+func (r *Runner) NewKVWatcher() *KVWatcher {
+	return &KVWatcher{
+		core: r.core,
+		ch:   make(chan kvView, 1),
+	}
+}
+
+// Next blocks until some keys changed since the last call and returns the changes sorted by key
+func (w *KVWatcher) Next(ctx context.Context) ([]KVChange, error) {
+	if err := w.core.checkStarted(); err != nil {
+		return nil, err
+	}
+	view, err := w.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes := diffKeyValues(w.values, view.values)
+	w.revision = view.revision
+	w.values = view.values
+	return changes, nil
+}
+
+// Watch returns the next changes, or nil when Next returns an error.
+//
+// Deprecated: use Next, which tells why no changes are returned
+func (w *KVWatcher) Watch(ctx context.Context) []KVChange {
+	changes, _ := w.Next(ctx)
+	return changes
+}
+
+func (w *KVWatcher) fetch(ctx context.Context) (kvView, error) {
+	// drop the view sent after a cancelled call, the request gets the current one
+	select {
+	case <-w.ch:
+	default:
+	}
+
+	select {
+	case w.core.requestChan <- fetchKVRequest{lastRevision: w.revision, respChan: w.ch}:
+	case <-ctx.Done():
+		return kvView{}, ctx.Err()
+	case <-w.core.stopped:
+		return kvView{}, ErrStopped
+	}
+
+	select {
+	case view := <-w.ch:
+		return view, nil
+	case <-ctx.Done():
+		return kvView{}, ctx.Err()
+	case <-w.core.stopped:
+		return kvView{}, ErrStopped
+	}
+}
+
+func diffKeyValues(old, values map[string]string) []KVChange {
+	var changes []KVChange
+	for k, v := range values {
+		if previous, ok := old[k]; !ok || previous != v {
+			changes = append(changes, KVChange{Key: k, Value: v})
+		}
+	}
+	for k := range old {
+		if _, ok := values[k]; !ok {
+			changes = append(changes, KVChange{Key: k, Deleted: true})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
@@ -0,0 +1,349 @@
+package crdtex
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRegisterLess(t *testing.T) {
+	t.Parallel()
+
+	table := []struct {
+		name    string
+		a       Register
+		aWriter string
+		b       Register
+		bWriter string
+		less    bool
+	}{
+		{
+			name: "smaller-timestamp",
+			a:    Register{Value: "a", Timestamp: 10}, aWriter: "node-2",
+			b: Register{Value: "b", Timestamp: 11}, bWriter: "node-1",
+			less: true,
+		},
+		{
+			name: "greater-timestamp",
+			a:    Register{Value: "a", Timestamp: 12}, aWriter: "node-1",
+			b: Register{Value: "b", Timestamp: 11}, bWriter: "node-2",
+			less: false,
+		},
+		{
+			name: "same-timestamp-smaller-writer",
+			a:    Register{Value: "a", Timestamp: 10}, aWriter: "node-1",
+			b: Register{Value: "b", Timestamp: 10}, bWriter: "node-2",
+			less: true,
+		},
+		{
+			name: "same-timestamp-and-writer-deleted-wins",
+			a:    Register{Value: "a", Timestamp: 10}, aWriter: "node-1",
+			b: Register{Deleted: true, Timestamp: 10}, bWriter: "node-1",
+			less: true,
+		},
+		{
+			name: "equal",
+			a:    Register{Value: "a", Timestamp: 10}, aWriter: "node-1",
+			b: Register{Value: "a", Timestamp: 10}, bWriter: "node-1",
+			less: false,
+		},
+	}
+
+	for _, e := range table {
+		e := e
+		t.Run(e.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, e.less, registerLess(e.a, e.aWriter, e.b, e.bWriter))
+		})
+	}
+}
+
+func TestState_KeyValues(t *testing.T) {
+	t.Parallel()
+
+	s := State{
+		"node-1": {Data: map[string]Register{
+			"a": {Value: "a1", Timestamp: 10},
+			"b": {Value: "b1", Timestamp: 30},
+		}},
+		"node-2": {Data: map[string]Register{
+			"a": {Value: "a2", Timestamp: 20},
+			"b": {Deleted: true, Timestamp: 20},
+			"c": {Deleted: true, Timestamp: 20},
+		}},
+	}
+
+	assert.Equal(t, map[string]string{
+		"a": "a2",
+		"b": "b1",
+	}, keyValues(s))
+}
+
+func TestCoreService_KV__Set_And_Delete(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	s.getNow = func() time.Time { return time.Unix(0, 1000) }
+	s.init(context.Background())
+
+	s.setValue("key-1", "value-1")
+	assert.Equal(t, Entry{
+		Term: 1, Timestamp: 100, Version: 2,
+		Data: map[string]Register{
+			"key-1": {Value: "value-1", Timestamp: 1000},
+		},
+	}, s.state.entry("self-addr"))
+	assert.Equal(t, map[string]string{"key-1": "value-1"}, s.kv)
+	assert.Equal(t, uint64(1), s.kvRevision)
+
+	s.deleteValue("key-1")
+	assert.Equal(t, Entry{
+		Term: 1, Timestamp: 100, Version: 3,
+		Data: map[string]Register{
+			"key-1": {Deleted: true, Timestamp: 1001},
+		},
+	}, s.state.entry("self-addr"))
+	assert.Equal(t, map[string]string{}, s.kv)
+	assert.Equal(t, uint64(2), s.kvRevision)
+}
+
+func TestCoreService_KV__Compact_Superseded_Registers(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	s.getNow = func() time.Time { return time.Unix(0, 1000) }
+	s.init(context.Background())
+	s.setValue("key-1", "value-1")
+	s.setValue("key-2", "value-2")
+
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1, Data: map[string]Register{
+			"key-1": {Value: "remote", Timestamp: 2000},
+			"key-2": {Value: "older", Timestamp: 500},
+		}},
+	})
+
+	assert.Equal(t, map[string]string{"key-1": "remote", "key-2": "value-2"}, s.kv)
+	assert.Equal(t, Entry{
+		Term: 1, Timestamp: 100, Version: 4,
+		Data: map[string]Register{
+			"key-2": {Value: "value-2", Timestamp: 1001},
+		},
+	}, s.state.entry("self-addr"))
+}
+
+func TestCoreService_KV__Adopt_Previous_Incarnation(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	s.getNow = func() time.Time { return time.Unix(0, 1000) }
+	s.init(context.Background())
+	s.setValue("key-2", "new")
+
+	s.updateWithState(State{
+		"self-addr": {Term: 1, Timestamp: 50, Version: 7, Data: map[string]Register{
+			"key-1": {Value: "old", Timestamp: 900},
+			"key-2": {Value: "old", Timestamp: 800},
+			"key-3": {Value: "newer", Timestamp: 1500},
+		}},
+	})
+
+	assert.Equal(t, map[string]string{"key-1": "old", "key-2": "new", "key-3": "newer"}, s.kv)
+	assert.Equal(t, Entry{
+		Term: 1, Timestamp: 100, Version: 3,
+		Data: map[string]Register{
+			"key-1": {Value: "old", Timestamp: 900},
+			"key-2": {Value: "new", Timestamp: 1000},
+			"key-3": {Value: "newer", Timestamp: 1500},
+		},
+	}, s.state.entry("self-addr"))
+
+	// new writes are after the adopted ones
+	s.setValue("key-1", "value")
+	assert.Equal(t, Register{Value: "value", Timestamp: 1501}, s.selfData["key-1"])
+}
+
+func TestCoreService_KV__Tombstone_Wins_Over_Older_Value(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	s.getNow = func() time.Time { return time.Unix(0, 1000) }
+	s.init(context.Background())
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1, Data: map[string]Register{
+			"key-1": {Value: "remote", Timestamp: 500},
+		}},
+	})
+	assert.Equal(t, map[string]string{"key-1": "remote"}, s.kv)
+
+	s.deleteValue("key-1")
+	assert.Equal(t, map[string]string{}, s.kv)
+
+	// the remote node still has its older value
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 2, Data: map[string]Register{
+			"key-1": {Value: "remote", Timestamp: 500},
+		}},
+	})
+	assert.Equal(t, map[string]string{}, s.kv)
+	assert.Equal(t, Register{Deleted: true, Timestamp: 1000}, s.state.entry("self-addr").Data["key-1"])
+}
+
+func TestCoreService_KV__Notify_Watchers(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	s.getNow = func() time.Time { return time.Unix(0, 1000) }
+	s.init(context.Background())
+
+	respChan := make(chan kvView, 1)
+	fetchKVRequest{lastRevision: 0, respChan: respChan}.handle(context.Background(), s)
+	assert.Equal(t, 1, len(s.kvWaitList))
+
+	s.setValue("key-1", "value-1")
+	assert.Equal(t, kvView{revision: 1, values: map[string]string{"key-1": "value-1"}}, <-respChan)
+	assert.Equal(t, 0, len(s.kvWaitList))
+
+	// not changed
+	fetchKVRequest{lastRevision: 1, respChan: respChan}.handle(context.Background(), s)
+	s.setValue("key-1", "value-1")
+	assert.Equal(t, 1, len(s.kvWaitList))
+}
+
+func TestCoreService_KV__Cancelled_Next(t *testing.T) {
+	t.Parallel()
+
+	s := newTestCoreService(newCallbacksMock())
+	s.init(context.Background())
+	close(s.started)
+	go func() {
+		for {
+			s.run(context.Background())
+		}
+	}()
+
+	watcher := &KVWatcher{core: s, ch: make(chan kvView, 1)}
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		_, err := watcher.fetch(ctx)
+		cancel()
+		assert.Equal(t, context.DeadlineExceeded, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	waiting := 0
+	err := s.runAction(ctx, func(s *coreService, _ context.Context) {
+		waiting = len(s.kvWaitList)
+		s.setValue("key-1", "value-1")
+		s.setValue("key-2", "value-2")
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, waiting)
+
+	// the view of key-1 sent to the cancelled call is dropped
+	changes, err := watcher.Next(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []KVChange{
+		{Key: "key-1", Value: "value-1"},
+		{Key: "key-2", Value: "value-2"},
+	}, changes)
+}
+
+func TestDiffKeyValues(t *testing.T) {
+	t.Parallel()
+
+	changes := diffKeyValues(
+		map[string]string{"a": "1", "b": "2", "c": "3"},
+		map[string]string{"a": "1", "b": "4", "d": "5"},
+	)
+	assert.Equal(t, []KVChange{
+		{Key: "b", Value: "4"},
+		{Key: "c", Deleted: true},
+		{Key: "d", Value: "5"},
+	}, changes)
+}
+
+func TestRunner_KV__Replicated(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	options := func(remote string) []Option {
+		return []Option{
+			AddRemoteAddress(remote),
+			WithSyncDuration(10 * time.Millisecond),
+			WithExpireDuration(5 * time.Second),
+		}
+	}
+	first := net.newRunner("node-1", options("node-2")...)
+	second := net.newRunner("node-2", options("node-1")...)
+	assert.Equal(t, nil, first.Start(ctx))
+	assert.Equal(t, nil, second.Start(ctx))
+
+	watcher := second.NewKVWatcher()
+
+	assert.Equal(t, nil, first.Set(ctx, "endpoint", "10.0.0.1:8080"))
+	assert.Equal(t, []KVChange{{Key: "endpoint", Value: "10.0.0.1:8080"}}, watcher.Watch(ctx))
+
+	value, ok, err := second.Get(ctx, "endpoint")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, "10.0.0.1:8080", value)
+
+	assert.Equal(t, nil, second.Delete(ctx, "endpoint"))
+	assert.Equal(t, []KVChange{{Key: "endpoint", Deleted: true}}, watcher.Watch(ctx))
+
+	assert.Eventually(t, func() bool {
+		_, ok, err := first.Get(ctx, "endpoint")
+		return err == nil && !ok
+	}, 5*time.Second, 5*time.Millisecond)
+
+	cancelled, cancelWatch := context.WithCancel(ctx)
+	cancelWatch()
+	assert.Equal(t, []KVChange(nil), watcher.Watch(cancelled))
+}
+
+func TestRunner_KV__Restart_Without_Store(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx := context.Background()
+
+	newRunner := func(addr string, remote string) *Runner {
+		r := net.newRunner(addr,
+			AddRemoteAddress(remote),
+			WithSyncDuration(10*time.Millisecond),
+		)
+		assert.Equal(t, nil, r.Start(ctx))
+		return r
+	}
+	hasValue := func(r *Runner) func() bool {
+		return func() bool {
+			value, ok, err := r.Get(ctx, "key")
+			return err == nil && ok && value == "value"
+		}
+	}
+
+	r1 := newRunner("node-1", "node-2")
+	r2 := newRunner("node-2", "node-1")
+	defer func() { _ = r2.Stop(ctx) }()
+
+	assert.Equal(t, nil, r1.Set(ctx, "key", "value"))
+	assert.Eventually(t, hasValue(r2), 5*time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, nil, r1.Stop(ctx))
+	restarted := newRunner("node-1", "node-2")
+	defer func() { _ = restarted.Stop(ctx) }()
+
+	assert.Eventually(t, hasValue(restarted), 5*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, hasValue(r2), 5*time.Second, 5*time.Millisecond)
+
+	state, err := r2.UpdateState(ctx, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "value", state["node-1"].Data["key"].Value)
+}
@@ -0,0 +1,131 @@
+package crdtex
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var loadHarness = flag.Bool("crdtex.load", false, "run the Update load harness")
+
+// loadResult is the outcome of one load level of the harness
+type loadResult struct {
+	concurrency int
+	updates     int64
+	rejected    int64
+	throughput  float64
+	p50         time.Duration
+	p99         time.Duration
+	maxQueue    int
+}
+
+// saturated reports whether the update channel was full
+func (r loadResult) saturated() bool {
+	return r.rejected > 0 || r.maxQueue >= updateChanSize
+}
+
+func (r loadResult) String() string {
+	return fmt.Sprintf("concurrency=%-5d updates=%-8d rejected=%-8d throughput=%8.0f/s p50=%-10v p99=%-10v "+
+		"max_queue=%d/%d saturated=%v",
+		r.concurrency, r.updates, r.rejected, r.throughput, r.p50, r.p99, r.maxQueue, updateChanSize, r.saturated())
+}
+
+// runLoad drives concurrency goroutines calling UpdateState for duration,
+// while sampling the depth of the update channel
+func runLoad(r *Runner, concurrency int, duration time.Duration) loadResult {
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
+
+	var maxQueue int64
+	sampleDone := make(chan struct{})
+	go func() {
+		defer close(sampleDone)
+		ticker := time.NewTicker(100 * time.Microsecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if depth := int64(len(r.core.updateChan)); depth > atomic.LoadInt64(&maxQueue) {
+					atomic.StoreInt64(&maxQueue, depth)
+				}
+			}
+		}
+	}()
+
+	var version uint64
+	var rejected int64
+	var mut sync.Mutex
+	var latencies []time.Duration
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var local []time.Duration
+			for ctx.Err() == nil {
+				v := atomic.AddUint64(&version, 1)
+				begin := time.Now()
+				_, err := r.UpdateState(ctx, State{"node-1": {Term: 1, Timestamp: 2, Version: v + 1}})
+				if err == ErrOverloaded {
+					atomic.AddInt64(&rejected, 1)
+					runtime.Gosched()
+					continue
+				}
+				if err != nil {
+					break
+				}
+				local = append(local, time.Since(begin))
+			}
+			mut.Lock()
+			latencies = append(latencies, local...)
+			mut.Unlock()
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	<-sampleDone
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result := loadResult{
+		concurrency: concurrency,
+		updates:     int64(len(latencies)),
+		rejected:    rejected,
+		throughput:  float64(len(latencies)) / elapsed.Seconds(),
+		maxQueue:    int(maxQueue),
+	}
+	if len(latencies) > 0 {
+		result.p50 = latencies[len(latencies)/2]
+		result.p99 = latencies[len(latencies)*99/100]
+	}
+	return result
+}
+
+// TestLoadHarness drives an increasing number of concurrent UpdateState calls
+// to find where the update channel saturates and the callers wait, run it with:
+//
+//	go test -run TestLoadHarness -crdtex.load -v .
+func TestLoadHarness(t *testing.T) {
+	if !*loadHarness {
+		t.Skip("enable with -crdtex.load")
+	}
+
+	for _, n := range benchClusterSizes {
+		ctx, cancel := context.WithCancel(context.Background())
+		r := startBenchRunner(ctx, n)
+
+		t.Logf("nodes=%d", n)
+		for _, concurrency := range []int{16, 64, 256, 1024, 4096} {
+			t.Log(runLoad(r, concurrency, time.Second))
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,23 @@
+package crdtex
+
+// Logger is a leveled key/value logger, keysAndValues are alternating keys and values.
+// It is satisfied by *slog.Logger
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+}
+
+type noopLogger struct {
+}
+
+var _ Logger = noopLogger{}
+
+// Debug does nothing
+func (noopLogger) Debug(string, ...interface{}) {}
+
+// Info does nothing
+func (noopLogger) Info(string, ...interface{}) {}
+
+// Warn does nothing
+func (noopLogger) Warn(string, ...interface{}) {}
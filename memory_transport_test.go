@@ -0,0 +1,97 @@
+package crdtex
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var errNodeUnreachable = errors.New("node unreachable")
+
+// memoryNetwork connects runners in the same process
+type memoryNetwork struct {
+	mut     sync.Mutex
+	runners map[string]*Runner
+	down    map[string]bool
+}
+
+func newMemoryNetwork() *memoryNetwork {
+	return &memoryNetwork{
+		runners: map[string]*Runner{},
+		down:    map[string]bool{},
+	}
+}
+
+func (n *memoryNetwork) newRunner(addr string, options ...Option) *Runner {
+	r := NewRunner(&memoryTransport{net: n, self: addr}, addr, options...)
+
+	n.mut.Lock()
+	n.runners[addr] = r
+	n.mut.Unlock()
+
+	return r
+}
+
+func (n *memoryNetwork) setDown(addr string, down bool) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+	n.down[addr] = down
+}
+
+func (n *memoryNetwork) getRunner(from string, to string) (*Runner, error) {
+	n.mut.Lock()
+	defer n.mut.Unlock()
+
+	if n.down[from] || n.down[to] {
+		return nil, errNodeUnreachable
+	}
+	r, ok := n.runners[to]
+	if !ok {
+		return nil, errNodeUnreachable
+	}
+	return r, nil
+}
+
+type memoryTransport struct {
+	net  *memoryNetwork
+	self string
+}
+
+var _ Interface = &memoryTransport{}
+var _ Prober = &memoryTransport{}
+var _ AntiEntropy = &memoryTransport{}
+
+func (t *memoryTransport) Start(ctx context.Context) {
+	<-ctx.Done()
+}
+
+func (t *memoryTransport) UpdateRemote(ctx context.Context, addr string, state State) (State, error) {
+	r, err := t.net.getRunner(t.self, addr)
+	if err != nil {
+		return nil, err
+	}
+	return r.UpdateState(ctx, state)
+}
+
+func (t *memoryTransport) Ping(_ context.Context, addr string) error {
+	_, err := t.net.getRunner(t.self, addr)
+	return err
+}
+
+func (t *memoryTransport) PingIndirect(ctx context.Context, via string, addr string) error {
+	if _, err := t.net.getRunner(t.self, via); err != nil {
+		return err
+	}
+	viaTransport := &memoryTransport{net: t.net, self: via}
+	return viaTransport.Ping(ctx, addr)
+}
+
+func (t *memoryTransport) AntiEntropy(
+	ctx context.Context, addr string, req AntiEntropyRequest,
+) (AntiEntropyResponse, error) {
+	r, err := t.net.getRunner(t.self, addr)
+	if err != nil {
+		return AntiEntropyResponse{}, err
+	}
+	return r.AntiEntropy(ctx, req)
+}
@@ -0,0 +1,79 @@
+package crdtex
+
+import "time"
+
+// Metrics is notified about the events of the core.
+// All methods are called from the core goroutine, so they must not block,
+// except UpdateRejected which is called from the goroutines calling Runner.UpdateState
+type Metrics interface {
+	// SyncAttempted is called when the state is sent to addr
+	SyncAttempted(addr string)
+	// SyncSucceeded is called when the state is successfully exchanged with addr
+	SyncSucceeded(addr string)
+	// SyncFailed is called when the state can not be exchanged with addr
+	SyncFailed(addr string)
+
+	// MergeDuration is called after merging a received state
+	MergeDuration(d time.Duration)
+	// StateSize is called with the number of entries after the state changed
+	StateSize(size int)
+	// GossipSent is called with delta gossip when a state is sent to addr, with the encoded size
+	// of the entries sent, and of the entries skipped because addr already acknowledged them
+	GossipSent(addr string, sentBytes int, savedBytes int)
+
+	// LeaderChanged is called when the computed leader changed
+	LeaderChanged(leader string)
+	// MemberExpired is called when addr is considered out of sync
+	MemberExpired(addr string)
+	// RunnerStarted is called whenever Start is invoked on this node
+	RunnerStarted()
+
+	// UpdateQueueDepth is called with the number of updates still queued when an update is handled
+	UpdateQueueDepth(depth int)
+	// UpdateRejected is called with WithFailFastUpdates when an update is rejected because the queue is full
+	UpdateRejected()
+}
+
+type noopMetrics struct {
+}
+
+var _ Metrics = noopMetrics{}
+
+// metricsEnabled reports whether Metrics were set with WithMetrics
+func (o serviceOptions) metricsEnabled() bool {
+	_, noop := o.metrics.(noopMetrics)
+	return !noop
+}
+
+// SyncAttempted does nothing
+func (noopMetrics) SyncAttempted(string) {}
+
+// SyncSucceeded does nothing
+func (noopMetrics) SyncSucceeded(string) {}
+
+// SyncFailed does nothing
+func (noopMetrics) SyncFailed(string) {}
+
+// MergeDuration does nothing
+func (noopMetrics) MergeDuration(time.Duration) {}
+
+// StateSize does nothing
+func (noopMetrics) StateSize(int) {}
+
+// GossipSent does nothing
+func (noopMetrics) GossipSent(string, int, int) {}
+
+// LeaderChanged does nothing
+func (noopMetrics) LeaderChanged(string) {}
+
+// MemberExpired does nothing
+func (noopMetrics) MemberExpired(string) {}
+
+// RunnerStarted does nothing
+func (noopMetrics) RunnerStarted() {}
+
+// UpdateQueueDepth does nothing
+func (noopMetrics) UpdateQueueDepth(int) {}
+
+// UpdateRejected does nothing
+func (noopMetrics) UpdateRejected() {}
@@ -7,6 +7,34 @@ type serviceOptions struct {
 	remoteAddresses   []string
 	syncDuration      time.Duration
 	expireDuration    time.Duration
+	failureDetector   FailureDetector
+	metrics           Metrics
+	logger            Logger
+	tracer            Tracer
+	leaseDuration     time.Duration
+	maxClockDrift     time.Duration
+
+	minClusterSize      int
+	bootstrapSyncRounds int
+
+	probeEnabled   bool
+	indirectProbes int
+	suspectTimeout time.Duration
+
+	store            Store
+	snapshotInterval time.Duration
+
+	nodeID string
+
+	clock *HLC
+
+	crdts map[string]CRDT
+
+	deltaGossip bool
+
+	antiEntropyRounds int
+
+	failFastUpdates bool
 }
 
 // Option ...
@@ -15,6 +43,9 @@ type Option func(opts *serviceOptions)
 func defaultServiceOptions() serviceOptions {
 	return serviceOptions{
 		callRemoteTimeout: 5 * time.Second,
+		metrics:           noopMetrics{},
+		logger:            noopLogger{},
+		tracer:            noopTracer{},
 		syncDuration:      5 * time.Second,
 		expireDuration:    60 * time.Second,
 	}
@@ -25,9 +56,27 @@ func computeOptions(options ...Option) serviceOptions {
 	for _, o := range options {
 		o(&opts)
 	}
+	if opts.failureDetector == nil {
+		opts.failureDetector = NewFixedFailureDetector(opts.expireDuration)
+	}
+	if opts.leaseDuration > 0 {
+		validateLease(opts)
+	}
 	return opts
 }
 
+// validateLease checks that followers can not expire the leader while it holds its lease,
+// which is only known for the fixed failure detector
+func validateLease(opts serviceOptions) {
+	fixed, ok := opts.failureDetector.(fixedFailureDetector)
+	if !ok {
+		panic("crdtex: leader lease requires the fixed failure detector")
+	}
+	if opts.leaseDuration >= fixed.expireDuration-opts.maxClockDrift {
+		panic("crdtex: lease duration must be less than expire duration minus max clock drift")
+	}
+}
+
 // AddRemoteAddress adds a remote address
 func AddRemoteAddress(addr string) Option {
 	return func(opts *serviceOptions) {
@@ -48,3 +97,153 @@ func WithExpireDuration(d time.Duration) Option {
 		opts.expireDuration = d
 	}
 }
+
+// WithFailureDetector replaces the default fixed expire duration failure detector
+func WithFailureDetector(detector FailureDetector) Option {
+	return func(opts *serviceOptions) {
+		opts.failureDetector = detector
+	}
+}
+
+// WithMetrics sets the Metrics notified about the events of the core
+func WithMetrics(metrics Metrics) Option {
+	return func(opts *serviceOptions) {
+		opts.metrics = metrics
+	}
+}
+
+// WithLogger sets the Logger used to log the events of the core
+func WithLogger(logger Logger) Option {
+	return func(opts *serviceOptions) {
+		opts.logger = logger
+	}
+}
+
+// WithTracer sets the Tracer used to trace sync rounds, Interface.UpdateRemote calls and anti-entropy exchanges
+func WithTracer(tracer Tracer) Option {
+	return func(opts *serviceOptions) {
+		opts.tracer = tracer
+	}
+}
+
+// WithLeaderLease enables the leader lease: the context passed to Start is cancelled
+// when the leader has not successfully synced with a majority of the cluster within leaseDuration.
+// The cluster is made of the members in sync and of the remote addresses not seen yet.
+// leaseDuration must be less than the expire duration minus maxClockDrift, so that followers
+// can assume the old leader has stopped before electing a new one.
+// leaseDuration should be long enough for the leader to sync with all remote addresses.
+// The lease can not be used with another FailureDetector than the fixed one,
+// which may expire the leader before it has lost its lease
+func WithLeaderLease(leaseDuration time.Duration, maxClockDrift time.Duration) Option {
+	return func(opts *serviceOptions) {
+		opts.leaseDuration = leaseDuration
+		opts.maxClockDrift = maxClockDrift
+	}
+}
+
+// WithMinClusterSize prevents the leader from being started until at least
+// minSize members (self included) have been seen in sync.
+// Until then no leader is returned by the LeaderWatcher nor shown by DebugInfo.
+// Once reached, the cluster is considered bootstrapped and the condition is never checked again
+func WithMinClusterSize(minSize int) Option {
+	return func(opts *serviceOptions) {
+		opts.minClusterSize = minSize
+	}
+}
+
+// WithBootstrapSyncRounds prevents the leader from being started until
+// the node has completed the given number of sync rounds.
+// Until then no leader is returned by the LeaderWatcher nor shown by DebugInfo
+func WithBootstrapSyncRounds(rounds int) Option {
+	return func(opts *serviceOptions) {
+		opts.bootstrapSyncRounds = rounds
+	}
+}
+
+// WithProbing enables SWIM-style probing: every sync round a random member is pinged directly,
+// and when the direct ping fails, indirectCount other members are asked to ping it.
+// A member that cannot be probed is suspected, and considered out of sync after suspectTimeout
+// unless a newer entry of it is received in the meantime.
+// Zero suspectTimeout keeps suspected members until the failure detector expires them.
+// The Interface passed to NewRunner must implement Prober
+func WithProbing(indirectCount int, suspectTimeout time.Duration) Option {
+	return func(opts *serviceOptions) {
+		opts.probeEnabled = true
+		opts.indirectProbes = indirectCount
+		opts.suspectTimeout = suspectTimeout
+	}
+}
+
+// WithStore restores the state from store on start, and saves it in the background
+// every snapshotInterval (checked on sync rounds) and on shutdown.
+// Run returns once the snapshot of the shutdown is saved
+func WithStore(store Store, snapshotInterval time.Duration) Option {
+	return func(opts *serviceOptions) {
+		opts.store = store
+		opts.snapshotInterval = snapshotInterval
+	}
+}
+
+// WithNodeID sets a stable identity of this node, separate from its network address.
+// The node is keyed by id in the State, so that a restart or an address change
+// is recognized as a new incarnation of the same node.
+// A new incarnation keeps the seniority of the previous one: its timestamp is restored from the Store,
+// or without a Store, adopted from the entry of the previous incarnation received from the other nodes
+// before the entry of this node is first bumped. A node restarted when no other node remembers it
+// becomes the youngest member
+func WithNodeID(id string) Option {
+	return func(opts *serviceOptions) {
+		opts.nodeID = id
+	}
+}
+
+// WithHLC uses clock to generate the timestamp and the versions of this node,
+// the clock is updated with the entries of every received State.
+// The timestamp used for the election is taken again once the first exchange with every
+// remote address has returned, so a joining node is younger than the nodes it has observed
+// whatever the skew of the wall clocks. Versions and the timestamps renewed by StepDown
+// are also ordered after the observed entries
+func WithHLC(clock *HLC) Option {
+	return func(opts *serviceOptions) {
+		opts.clock = clock
+	}
+}
+
+// WithCRDT registers a CRDT with its empty value under name,
+// it is gossiped and merged through the sync loop of the Runner
+func WithCRDT(name string, empty CRDT) Option {
+	return func(opts *serviceOptions) {
+		if opts.crdts == nil {
+			opts.crdts = map[string]CRDT{}
+		}
+		opts.crdts[name] = empty
+	}
+}
+
+// WithDeltaGossip sends to each remote address only the entries changed since
+// the state it returned in the last successful exchange, instead of the full state.
+// The full state is sent on the first exchange, after a failure,
+// and when the remote address no longer has the entries it acknowledged
+func WithDeltaGossip() Option {
+	return func(opts *serviceOptions) {
+		opts.deltaGossip = true
+	}
+}
+
+// WithAntiEntropy replaces the state exchange of every everyRounds sync rounds by an anti-entropy exchange:
+// the hash trees over the keys of the states are compared, and only the entries that differ are transferred.
+// The Interface passed to NewRunner must implement AntiEntropy
+func WithAntiEntropy(everyRounds int) Option {
+	return func(opts *serviceOptions) {
+		opts.antiEntropyRounds = everyRounds
+	}
+}
+
+// WithFailFastUpdates makes UpdateState return ErrOverloaded when the queue of updates is full,
+// instead of waiting for the queue until its context is done.
+// The other node retries on its next sync round
+func WithFailFastUpdates() Option {
+	return func(opts *serviceOptions) {
+		opts.failFastUpdates = true
+	}
+}
@@ -0,0 +1,331 @@
+package crdtex
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+)
+
+// Tag identifies an add to an ORSet, by the node that added the element, the incarnation of this node
+// and a sequence number of this node. The incarnation keeps the tags unique when the node restarts
+// before it has recovered its sequence number
+type Tag struct {
+	Node        string `json:"node"`
+	Incarnation uint64 `json:"incarnation"`
+	Seq         uint64 `json:"seq"`
+}
+
+func tagLess(a, b Tag) bool {
+	if a.Node != b.Node {
+		return a.Node < b.Node
+	}
+	if a.Incarnation != b.Incarnation {
+		return a.Incarnation < b.Incarnation
+	}
+	return a.Seq < b.Seq
+}
+
+type tagSet map[Tag]struct{}
+
+func (s tagSet) sorted() []Tag {
+	tags := make([]Tag, 0, len(s))
+	for t := range s {
+		tags = append(tags, t)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tagLess(tags[i], tags[j])
+	})
+	return tags
+}
+
+// ORSet is an observed-remove set of strings, where a concurrent add and remove resolve add-wins.
+// Every add has a unique tag, a remove records the tags it observed as tombstones.
+// Tombstones are compacted once the node that added a tag has dropped it from its contribution
+type ORSet struct {
+	adds  map[string]tagSet
+	tombs tagSet
+	seqs  map[string]uint64
+}
+
+var _ CRDT = ORSet{}
+var _ Compacter = ORSet{}
+
+// NewORSet creates an empty ORSet
+func NewORSet() ORSet {
+	return ORSet{
+		adds:  map[string]tagSet{},
+		tombs: tagSet{},
+		seqs:  map[string]uint64{},
+	}
+}
+
+func (s ORSet) clone() ORSet {
+	result := NewORSet()
+	for e, tags := range s.adds {
+		copied := make(tagSet, len(tags))
+		for t := range tags {
+			copied[t] = struct{}{}
+		}
+		result.adds[e] = copied
+	}
+	for t := range s.tombs {
+		result.tombs[t] = struct{}{}
+	}
+	for n, seq := range s.seqs {
+		result.seqs[n] = seq
+	}
+	return result
+}
+
+func (s ORSet) addTag(e string, t Tag) {
+	tags, ok := s.adds[e]
+	if !ok {
+		tags = tagSet{}
+		s.adds[e] = tags
+	}
+	tags[t] = struct{}{}
+	if t.Seq > s.seqs[t.Node] {
+		s.seqs[t.Node] = t.Seq
+	}
+}
+
+// Add returns the set with e added by the given incarnation of node under a new tag
+func (s ORSet) Add(node string, incarnation uint64, e string) ORSet {
+	result := s.clone()
+	result.addTag(e, Tag{Node: node, Incarnation: incarnation, Seq: s.seqs[node] + 1})
+	return result
+}
+
+// Remove returns the set with the observed tags of e removed
+func (s ORSet) Remove(e string) ORSet {
+	result := s.clone()
+	for t := range s.adds[e] {
+		result.tombs[t] = struct{}{}
+	}
+	return result
+}
+
+// Contains reports whether e has a tag that is not removed
+func (s ORSet) Contains(e string) bool {
+	for t := range s.adds[e] {
+		if _, removed := s.tombs[t]; !removed {
+			return true
+		}
+	}
+	return false
+}
+
+// Elements returns the sorted elements of the set
+func (s ORSet) Elements() []string {
+	var elements []string
+	for e := range s.adds {
+		if s.Contains(e) {
+			elements = append(elements, e)
+		}
+	}
+	sort.Strings(elements)
+	return elements
+}
+
+// Merge returns the union of the tags, the tombstones and the sequence numbers
+func (s ORSet) Merge(other CRDT) CRDT {
+	o := other.(ORSet)
+	result := s.clone()
+	for e, tags := range o.adds {
+		for t := range tags {
+			result.addTag(e, t)
+		}
+	}
+	for t := range o.tombs {
+		result.tombs[t] = struct{}{}
+	}
+	for n, seq := range o.seqs {
+		if seq > result.seqs[n] {
+			result.seqs[n] = seq
+		}
+	}
+	return result
+}
+
+// Delta returns the tags, tombstones and sequence numbers not included in since
+func (s ORSet) Delta(since CRDT) CRDT {
+	o := since.(ORSet)
+	result := NewORSet()
+	for e, tags := range s.adds {
+		for t := range tags {
+			if _, ok := o.adds[e][t]; !ok {
+				result.addTag(e, t)
+			}
+		}
+	}
+	for t := range s.tombs {
+		if _, ok := o.tombs[t]; !ok {
+			result.tombs[t] = struct{}{}
+		}
+	}
+	for n, seq := range s.seqs {
+		if seq > o.seqs[n] {
+			result.seqs[n] = seq
+		}
+	}
+	return result
+}
+
+func (s ORSet) hasTag(t Tag) bool {
+	for _, tags := range s.adds {
+		if _, ok := tags[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Compact drops the tags added by self that a member has removed,
+// and the tombstones of the tags no longer added by the contribution of their node
+func (s ORSet) Compact(self string, contributions map[string]CRDT) CRDT {
+	result := s.clone()
+	for e, tags := range result.adds {
+		for t := range tags {
+			if t.Node == self && anyTombstone(contributions, t) {
+				delete(tags, t)
+			}
+		}
+		if len(tags) == 0 {
+			delete(result.adds, e)
+		}
+	}
+
+	for t := range result.tombs {
+		owner, ok := contributions[t.Node]
+		if t.Node == self {
+			owner, ok = result, true
+		}
+		if ok && !owner.(ORSet).hasTag(t) {
+			delete(result.tombs, t)
+		}
+	}
+	return result
+}
+
+func anyTombstone(contributions map[string]CRDT, t Tag) bool {
+	for _, c := range contributions {
+		if _, ok := c.(ORSet).tombs[t]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+type orSetJSON struct {
+	Adds  map[string][]Tag  `json:"adds,omitempty"`
+	Tombs []Tag             `json:"tombs,omitempty"`
+	Seqs  map[string]uint64 `json:"seqs,omitempty"`
+}
+
+// Encode encodes the set as JSON
+func (s ORSet) Encode() ([]byte, error) {
+	var encoded orSetJSON
+	if len(s.adds) > 0 {
+		encoded.Adds = map[string][]Tag{}
+		for e, tags := range s.adds {
+			encoded.Adds[e] = tags.sorted()
+		}
+	}
+	if len(s.tombs) > 0 {
+		encoded.Tombs = s.tombs.sorted()
+	}
+	if len(s.seqs) > 0 {
+		encoded.Seqs = s.seqs
+	}
+	return json.Marshal(encoded)
+}
+
+// Decode decodes a JSON encoded ORSet
+func (s ORSet) Decode(data []byte) (CRDT, error) {
+	var encoded orSetJSON
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return nil, err
+	}
+
+	result := NewORSet()
+	for e, tags := range encoded.Adds {
+		for _, t := range tags {
+			result.addTag(e, t)
+		}
+	}
+	for _, t := range encoded.Tombs {
+		result.tombs[t] = struct{}{}
+	}
+	for n, seq := range encoded.Seqs {
+		if seq > result.seqs[n] {
+			result.seqs[n] = seq
+		}
+	}
+	return result, nil
+}
+
+// Equal compares the tags, the tombstones and the sequence numbers
+func (s ORSet) Equal(other CRDT) bool {
+	o := other.(ORSet)
+	if len(s.adds) != len(o.adds) || !tagSetEqual(s.tombs, o.tombs) || len(s.seqs) != len(o.seqs) {
+		return false
+	}
+	for e, tags := range s.adds {
+		if !tagSetEqual(tags, o.adds[e]) {
+			return false
+		}
+	}
+	for n, seq := range s.seqs {
+		if o.seqs[n] != seq {
+			return false
+		}
+	}
+	return true
+}
+
+func tagSetEqual(a, b tagSet) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for t := range a {
+		if _, ok := b[t]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// AddToORSet adds e to the ORSet registered with name
+func (r *Runner) AddToORSet(ctx context.Context, name string, e string) error {
+	return r.updateCRDTOfType(ctx, name, func(node string, incarnation uint64, current CRDT) (CRDT, bool) {
+		s, ok := current.(ORSet)
+		if !ok {
+			return nil, false
+		}
+		return s.Add(node, incarnation, e), true
+	})
+}
+
+// RemoveFromORSet removes e from the ORSet registered with name
+func (r *Runner) RemoveFromORSet(ctx context.Context, name string, e string) error {
+	return r.updateCRDTOfType(ctx, name, func(_ string, _ uint64, current CRDT) (CRDT, bool) {
+		s, ok := current.(ORSet)
+		if !ok {
+			return nil, false
+		}
+		return s.Remove(e), true
+	})
+}
+
+// ORSetElements returns the sorted elements of the ORSet registered with name
+func (r *Runner) ORSetElements(ctx context.Context, name string) ([]string, error) {
+	value, err := r.CRDT(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := value.(ORSet)
+	if !ok {
+		return nil, ErrCRDTType
+	}
+	return s.Elements(), nil
+}
@@ -0,0 +1,218 @@
+package crdtex
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestORSet(t *testing.T) {
+	t.Parallel()
+
+	s := NewORSet().Add("node-1", 1, "a").Add("node-2", 1, "b").Add("node-1", 1, "c")
+	assert.Equal(t, []string{"a", "b", "c"}, s.Elements())
+
+	removed := s.Remove("b")
+	assert.False(t, removed.Contains("b"))
+	assert.Equal(t, []string{"a", "c"}, removed.Elements())
+
+	readded := removed.Add("node-1", 1, "b")
+	assert.True(t, readded.Contains("b"))
+	assert.Equal(t, []string{"a", "c"}, s.Remove("b").Elements())
+
+	data, err := removed.Encode()
+	assert.Equal(t, nil, err)
+	assert.Equal(t,
+		`{"adds":{"a":[{"node":"node-1","incarnation":1,"seq":1}],"b":[{"node":"node-2","incarnation":1,"seq":1}],`+
+			`"c":[{"node":"node-1","incarnation":1,"seq":2}]},`+
+			`"tombs":[{"node":"node-2","incarnation":1,"seq":1}],"seqs":{"node-1":2,"node-2":1}}`,
+		string(data))
+
+	decoded, err := NewORSet().Decode([]byte(`{}`))
+	assert.Equal(t, nil, err)
+	assert.True(t, NewORSet().Equal(decoded))
+}
+
+func TestORSet__Add_Wins(t *testing.T) {
+	t.Parallel()
+
+	base := NewORSet().Add("node-1", 1, "a")
+	removed := base.Remove("a")
+	added := base.Add("node-2", 1, "a")
+
+	assert.True(t, removed.Merge(added).(ORSet).Contains("a"))
+	assert.True(t, added.Merge(removed).(ORSet).Contains("a"))
+	assert.False(t, removed.Merge(base).(ORSet).Contains("a"))
+}
+
+func TestORSet__Restarted_Node(t *testing.T) {
+	t.Parallel()
+
+	removed := NewORSet().Add("node-1", 1, "a").Remove("a")
+
+	// the restarted node has lost its sequence numbers
+	restarted := NewORSet().Add("node-1", 2, "b")
+	assert.Equal(t, []string{"b"}, removed.Merge(restarted).(ORSet).Elements())
+}
+
+func TestCoreService_ORSet__Add_After_Restart(t *testing.T) {
+	t.Parallel()
+
+	s := newCoreService(newCallbacksMock(), nodeID{timestamp: 100, addr: "self-addr"},
+		computeOptions(
+			AddRemoteAddress("remote-addr-1"),
+			WithCRDT("set", NewORSet()),
+		),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+	s.init(context.Background())
+
+	// the remote node still has the tombstone of an add of the previous incarnation
+	removed, err := NewORSet().Add("self-addr", 50, "a").Remove("a").Encode()
+	assert.Equal(t, nil, err)
+	s.updateWithState(State{
+		"remote-addr-1": {Term: 1, Timestamp: 200, Version: 1, CRDTs: map[string][]byte{"set": removed}},
+	})
+
+	assert.Equal(t, nil, s.updateCRDT("set", func(current CRDT) CRDT {
+		return current.(ORSet).Add("self-addr", s.incarnation, "b")
+	}))
+	assert.Equal(t, []string{"b"}, s.crdts["set"].value.(ORSet).Elements())
+}
+
+func TestORSet__CRDT_Laws(t *testing.T) {
+	t.Parallel()
+
+	base := NewORSet().Add("node-1", 1, "a").Add("node-2", 1, "b")
+	assert.Equal(t, nil, CheckCRDTLaws(
+		NewORSet(),
+		base,
+		base.Remove("a"),
+		base.Add("node-2", 1, "a"),
+		base.Remove("b").Add("node-3", 1, "c"),
+		NewORSet().Add("node-1", 1, "a").Add("node-1", 1, "a"),
+	))
+}
+
+func TestORSet_Compact(t *testing.T) {
+	t.Parallel()
+
+	owner := NewORSet().Add("node-1", 1, "a").Add("node-1", 1, "b")
+	remover := NewORSet().Merge(owner.Delta(NewORSet())).(ORSet).Remove("a").Delta(owner).(ORSet)
+
+	// the owner drops its tag removed by another member
+	compacted := owner.Compact("node-1", map[string]CRDT{"node-1": owner, "node-2": remover}).(ORSet)
+	assert.Equal(t, []string{"b"}, compacted.Elements())
+	assert.False(t, compacted.hasTag(Tag{Node: "node-1", Incarnation: 1, Seq: 1}))
+
+	// the remover keeps its tombstone while the owner still adds the tag
+	assert.True(t, remover.Equal(remover.Compact("node-2", map[string]CRDT{"node-1": owner, "node-2": remover})))
+
+	// and drops it once the owner has dropped the tag
+	assert.True(t, NewORSet().Equal(remover.Compact("node-2", map[string]CRDT{"node-1": compacted, "node-2": remover})))
+
+	// the tombstone is kept while the owner is not a known member
+	assert.True(t, remover.Equal(remover.Compact("node-2", map[string]CRDT{"node-2": remover})))
+}
+
+func TestRunner_ORSet__Converge_And_Compact(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	addrs := []string{"node-1", "node-2", "node-3"}
+	var runners []*Runner
+	for i, addr := range addrs {
+		r := net.newRunner(addr,
+			AddRemoteAddress(addrs[(i+1)%len(addrs)]),
+			WithSyncDuration(10*time.Millisecond),
+			WithExpireDuration(5*time.Second),
+			WithCRDT("members", NewORSet()),
+			WithCRDT("requests", NewGCounter()),
+		)
+		runners = append(runners, r)
+		assert.Equal(t, nil, r.Start(ctx))
+	}
+
+	elementsEqual := func(r *Runner, expected []string) func() bool {
+		return func() bool {
+			elements, err := r.ORSetElements(ctx, "members")
+			return err == nil && assert.ObjectsAreEqual(expected, elements)
+		}
+	}
+
+	assert.Equal(t, nil, runners[0].AddToORSet(ctx, "members", "a"))
+	assert.Equal(t, nil, runners[1].AddToORSet(ctx, "members", "b"))
+	assert.Equal(t, nil, runners[2].AddToORSet(ctx, "members", "c"))
+	for _, r := range runners {
+		assert.Eventually(t, elementsEqual(r, []string{"a", "b", "c"}), 5*time.Second, 5*time.Millisecond)
+	}
+
+	assert.Equal(t, nil, runners[2].RemoveFromORSet(ctx, "members", "a"))
+	for _, r := range runners {
+		assert.Eventually(t, elementsEqual(r, []string{"b", "c"}), 5*time.Second, 5*time.Millisecond)
+	}
+
+	// the tag of a and its tombstone are compacted away
+	for _, r := range runners {
+		r := r
+		assert.Eventually(t, func() bool {
+			var compacted bool
+			err := r.core.runAction(ctx, func(s *coreService, _ context.Context) {
+				contribution := s.crdts["members"].contribution.(ORSet)
+				compacted = len(contribution.tombs) == 0 && !contribution.Contains("a")
+			})
+			return err == nil && compacted
+		}, 5*time.Second, 5*time.Millisecond)
+	}
+	assert.Eventually(t, elementsEqual(runners[0], []string{"b", "c"}), 5*time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, ErrCRDTType, runners[0].AddToORSet(ctx, "requests", "a"))
+	_, err := runners[0].ORSetElements(ctx, "requests")
+	assert.Equal(t, ErrCRDTType, err)
+}
+
+func TestRunner_ORSet__Restart_Without_Store(t *testing.T) {
+	t.Parallel()
+
+	net := newMemoryNetwork()
+	ctx := context.Background()
+
+	newRunner := func(addr string, remote string) *Runner {
+		r := net.newRunner(addr,
+			AddRemoteAddress(remote),
+			WithSyncDuration(10*time.Millisecond),
+			WithCRDT("set", NewORSet()),
+		)
+		assert.Equal(t, nil, r.Start(ctx))
+		return r
+	}
+	elementsEqual := func(r *Runner, expected ...string) func() bool {
+		return func() bool {
+			elements, err := r.ORSetElements(ctx, "set")
+			return err == nil && assert.ObjectsAreEqual(expected, elements)
+		}
+	}
+
+	r1 := newRunner("node-1", "node-2")
+	r2 := newRunner("node-2", "node-1")
+	defer func() { _ = r2.Stop(ctx) }()
+
+	assert.Equal(t, nil, r1.AddToORSet(ctx, "set", "a"))
+	assert.Eventually(t, elementsEqual(r2, "a"), 5*time.Second, 5*time.Millisecond)
+	assert.Equal(t, nil, r2.RemoveFromORSet(ctx, "set", "a"))
+	assert.Eventually(t, elementsEqual(r1), 5*time.Second, 5*time.Millisecond)
+
+	assert.Equal(t, nil, r1.Stop(ctx))
+	restarted := newRunner("node-1", "node-2")
+	defer func() { _ = restarted.Stop(ctx) }()
+
+	assert.Equal(t, nil, restarted.AddToORSet(ctx, "set", "b"))
+
+	assert.Eventually(t, elementsEqual(restarted, "b"), 5*time.Second, 5*time.Millisecond)
+	assert.Eventually(t, elementsEqual(r2, "b"), 5*time.Second, 5*time.Millisecond)
+}
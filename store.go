@@ -0,0 +1,138 @@
+package crdtex
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Snapshot is the persisted knowledge of a node
+type Snapshot struct {
+	State   State     `json:"state"`
+	Term    uint64    `json:"term"`
+	Version uint64    `json:"version"`
+	SavedAt time.Time `json:"saved_at"`
+}
+
+// Store persists snapshots of the local state across restarts.
+// Load is called from the core goroutine on start, and Save from a goroutine writing the snapshots
+// in the background, they are never called concurrently
+type Store interface {
+	// Load returns the last saved snapshot, ok is false if there is none
+	Load() (snapshot Snapshot, ok bool, err error)
+
+	// Save replaces the saved snapshot
+	Save(snapshot Snapshot) error
+}
+
+type fileStore struct {
+	path string
+}
+
+var _ Store = fileStore{}
+
+// NewFileStore creates a Store keeping the snapshot as JSON in the file at path.
+// The file is replaced atomically by writing to a temporary file then renaming it,
+// the directory is synced so that the rename survives a crash
+func NewFileStore(path string) Store {
+	return fileStore{
+		path: path,
+	}
+}
+
+// Load reads the snapshot file
+func (f fileStore) Load() (Snapshot, bool, error) {
+	data, err := ioutil.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return Snapshot{}, false, nil
+	}
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return Snapshot{}, false, err
+	}
+	return snapshot, true, nil
+}
+
+// Save writes the snapshot to a temporary file then renames it to the snapshot file
+func (f fileStore) Save(snapshot Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory, renames it to path
+// and syncs the directory
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the entries of the directory at path, such as a rename
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := dir.Sync(); err != nil {
+		_ = dir.Close()
+		return err
+	}
+	return dir.Close()
+}
+
+// LoadOrCreateNodeID reads the node ID stored in the file at path,
+// or generates a random one and stores it when the file does not exist
+func LoadOrCreateNodeID(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		id := strings.TrimSpace(string(data))
+		if id == "" {
+			return "", fmt.Errorf("crdtex: empty node id in %s", path)
+		}
+		return id, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	var random [16]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(random[:])
+	if err := writeFileAtomic(path, []byte(id+"\n")); err != nil {
+		return "", err
+	}
+	return id, nil
+}
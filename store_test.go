@@ -0,0 +1,298 @@
+package crdtex
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStore__Load_Not_Existed(t *testing.T) {
+	t.Parallel()
+
+	store := NewFileStore(filepath.Join(t.TempDir(), "snapshot.json"))
+
+	snapshot, ok, err := store.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, false, ok)
+	assert.Equal(t, Snapshot{}, snapshot)
+}
+
+func TestFileStore__Save_And_Load(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	store := NewFileStore(filepath.Join(dir, "snapshot.json"))
+
+	first := Snapshot{
+		State: State{
+			"self-addr":     {Term: 1, Timestamp: 100, Version: 3},
+			"remote-addr-1": {Term: 2, Timestamp: 80, Version: 5, OutOfSync: true},
+		},
+		Term:    1,
+		Version: 3,
+		SavedAt: mustParse("2021-06-05T10:20:00Z"),
+	}
+	assert.Equal(t, nil, store.Save(first))
+
+	second := first
+	second.Version = 4
+	assert.Equal(t, nil, store.Save(second))
+
+	snapshot, ok, err := store.Load()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, second, snapshot)
+
+	files, err := ioutil.ReadDir(dir)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(files))
+	assert.Equal(t, "snapshot.json", files[0].Name())
+}
+
+func TestFileStore__Load_Corrupted(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	assert.Equal(t, nil, ioutil.WriteFile(path, []byte("{invalid"), 0600))
+
+	_, ok, err := NewFileStore(path).Load()
+	assert.Error(t, err)
+	assert.Equal(t, false, ok)
+}
+
+func TestFileStore__Save_Dir_Not_Existed(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "not-existed", "snapshot.json")
+	err := NewFileStore(path).Save(Snapshot{})
+	assert.True(t, os.IsNotExist(err))
+}
+
+type memoryStore struct {
+	snapshot *Snapshot
+	loadErr  error
+	saves    []Snapshot
+}
+
+func (m *memoryStore) Load() (Snapshot, bool, error) {
+	if m.loadErr != nil {
+		return Snapshot{}, false, m.loadErr
+	}
+	if m.snapshot == nil {
+		return Snapshot{}, false, nil
+	}
+	return *m.snapshot, true, nil
+}
+
+func (m *memoryStore) Save(snapshot Snapshot) error {
+	m.saves = append(m.saves, snapshot)
+	m.snapshot = &snapshot
+	return nil
+}
+
+func TestCoreService_Store__Restore_On_Init(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{snapshot: &Snapshot{
+		State: State{
+			"self-addr":     {Term: 3, Timestamp: 50, Version: 20, OutOfSync: true},
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 5},
+			"remote-addr-2": {Term: 2, Timestamp: 90, Version: 7},
+		},
+		Term:    3,
+		Version: 20,
+		SavedAt: mustParse("2021-06-05T10:19:40Z"),
+	}}
+
+	methods := newCallbacksMock()
+	expireTimer := newTimerMock()
+	s := newTestCoreService(methods, WithStore(store, time.Minute))
+	s.expireTimer = expireTimer
+
+	s.init(context.Background())
+
+	assert.Equal(t, State{
+		"self-addr":     {Term: 3, Timestamp: 100, Version: 21},
+		"remote-addr-1": {Term: 1, Timestamp: 80, Version: 5},
+		"remote-addr-2": {Term: 2, Timestamp: 90, Version: 7},
+	}, s.getState())
+	assert.Equal(t, uint64(3), s.stateTerm)
+	assert.Equal(t, mustParse("2021-06-05T10:19:40Z"), s.lastUpdate["remote-addr-1"])
+
+	// expire at saved at + 30s
+	assert.Equal(t, []time.Duration{10 * time.Second}, []time.Duration{expireTimer.ResetCalls()[0].D})
+
+	assert.Equal(t, 1, len(methods.updateRemoteCalls()))
+	assert.Equal(t, s.getState(), methods.updateRemoteCalls()[0].State)
+}
+
+func TestCoreService_Store__Restore_Expired_Members(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{snapshot: &Snapshot{
+		State: State{
+			"remote-addr-1": {Term: 1, Timestamp: 80, Version: 5},
+		},
+		Term:    1,
+		Version: 4,
+		SavedAt: mustParse("2021-06-05T10:00:00Z"),
+	}}
+	s := newTestCoreService(newCallbacksMock(), WithStore(store, time.Minute))
+
+	s.init(context.Background())
+
+	assert.Equal(t, State{
+		"self-addr":     {Term: 1, Timestamp: 100, Version: 5},
+		"remote-addr-1": {Term: 1, Timestamp: 80, Version: 5, OutOfSync: true},
+	}, s.getState())
+
+	s.computeAndStartLeader(context.Background())
+	assert.Equal(t, "self-addr", s.leader.addr)
+}
+
+func TestCoreService_Store__Load_Error(t *testing.T) {
+	t.Parallel()
+
+	logger := &logRecorder{}
+	store := &memoryStore{loadErr: errors.New("load error")}
+	s := newTestCoreService(newCallbacksMock(), WithStore(store, time.Minute))
+	s.options.logger = logger
+
+	s.init(context.Background())
+
+	assert.Equal(t, State{
+		"self-addr": {Term: 1, Timestamp: 100, Version: 1},
+	}, s.getState())
+	assert.Equal(t, []logRecord{
+		{level: "warn", msg: "load snapshot failed", keysAndValues: []interface{}{"error", store.loadErr}},
+	}, logger.records)
+}
+
+func TestCoreService_Store__Save_Periodically_And_On_Shutdown(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{}
+	s := newTestCoreService(newCallbacksMock(), WithStore(store, time.Minute))
+	s.init(context.Background())
+
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, Snapshot{
+		State:   State{"self-addr": {Term: 1, Timestamp: 100, Version: 2}},
+		Term:    1,
+		Version: 2,
+		SavedAt: mustParse("2021-06-05T10:20:00Z"),
+	}, <-s.snapshotChan)
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:20:30Z") }
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, 0, len(s.snapshotChan))
+
+	s.getNow = func() time.Time { return mustParse("2021-06-05T10:21:00Z") }
+	s.handleSyncTimerExpired(context.Background())
+	assert.Equal(t, uint64(4), (<-s.snapshotChan).Version)
+
+	s.handleShutdown()
+	assert.Equal(t, Entry{Term: 1, Timestamp: 100, Version: 4, OutOfSync: true}, (<-s.snapshotChan).State["self-addr"])
+	assert.Equal(t, 0, len(store.saves))
+}
+
+func TestCoreService_Store__Writer_Saves_Latest(t *testing.T) {
+	t.Parallel()
+
+	store := &memoryStore{}
+	s := newTestCoreService(newCallbacksMock(), WithStore(store, time.Minute))
+	s.init(context.Background())
+
+	// not written yet, replaced by the next one
+	s.saveSnapshot(mustParse("2021-06-05T10:20:00Z"))
+	s.saveSnapshot(mustParse("2021-06-05T10:21:00Z"))
+
+	s.startSnapshotWriter()
+	s.stopSnapshotWriter()
+
+	assert.Equal(t, 1, len(store.saves))
+	assert.Equal(t, mustParse("2021-06-05T10:21:00Z"), store.saves[0].SavedAt)
+}
+
+func TestRunner_Store__Rejoin_With_Full_Knowledge(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+	net := newMemoryNetwork()
+
+	options := func(remote string) []Option {
+		return []Option{
+			AddRemoteAddress(remote),
+			WithSyncDuration(10 * time.Millisecond),
+			WithExpireDuration(5 * time.Second),
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go net.newRunner("node-1", options("node-2")...).Run(ctx)
+
+	firstCtx, firstCancel := context.WithCancel(context.Background())
+	first := net.newRunner("node-2", append(options("node-1"), WithStore(NewFileStore(path), 0))...)
+	assert.Equal(t, nil, first.Start(firstCtx))
+
+	assert.Eventually(t, func() bool {
+		info, err := first.DebugInfo(ctx)
+		return err == nil && len(info.Members) == 2
+	}, 5*time.Second, 5*time.Millisecond)
+	firstCancel()
+	<-first.Done()
+
+	// restart without any reachable remote
+	net.setDown("node-1", true)
+	secondCtx, secondCancel := context.WithCancel(context.Background())
+	second := net.newRunner("node-2", append(options("node-1"), WithStore(NewFileStore(path), 0))...)
+	assert.Equal(t, nil, second.Start(secondCtx))
+	defer func() {
+		secondCancel()
+		<-second.Done()
+	}()
+
+	info, err := second.DebugInfo(ctx)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(info.Members))
+
+	assert.Eventually(t, func() bool {
+		info, err := second.DebugInfo(ctx)
+		return err == nil && info.Leader == "node-1"
+	}, 5*time.Second, 5*time.Millisecond)
+}
+
+func TestLoadOrCreateNodeID(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "node-id")
+
+	id, err := LoadOrCreateNodeID(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 32, len(id))
+
+	loaded, err := LoadOrCreateNodeID(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, id, loaded)
+
+	assert.Equal(t, nil, ioutil.WriteFile(path, []byte("user-node-id\n"), 0600))
+	loaded, err = LoadOrCreateNodeID(path)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, "user-node-id", loaded)
+}
+
+func TestLoadOrCreateNodeID__Empty_File(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "node-id")
+	assert.Equal(t, nil, ioutil.WriteFile(path, []byte("\n"), 0600))
+
+	_, err := LoadOrCreateNodeID(path)
+	assert.Error(t, err)
+}
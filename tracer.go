@@ -0,0 +1,54 @@
+package crdtex
+
+import "context"
+
+// Span names and attribute keys used by the core
+const (
+	SpanSyncRound    = "crdtex.SyncRound"
+	SpanUpdateRemote = "crdtex.UpdateRemote"
+	SpanAntiEntropy  = "crdtex.AntiEntropy"
+
+	AttrPeer              = "crdtex.peer"
+	AttrStateSize         = "crdtex.state_size"
+	AttrResponseStateSize = "crdtex.response_state_size"
+	AttrOutcome           = "crdtex.outcome"
+	AttrLeader            = "crdtex.leader"
+)
+
+// Tracer creates spans around sync rounds, Interface.UpdateRemote calls and anti-entropy exchanges
+type Tracer interface {
+	// StartSpan starts a span as a child of the span in ctx (if any),
+	// the returned context contains the new span
+	StartSpan(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a traced operation
+type Span interface {
+	SetAttribute(key string, value interface{})
+	RecordError(err error)
+	End()
+}
+
+type noopTracer struct {
+}
+
+var _ Tracer = noopTracer{}
+
+// StartSpan returns ctx and a span that does nothing
+func (noopTracer) StartSpan(ctx context.Context, _ string) (context.Context, Span) {
+	return ctx, noopSpan{}
+}
+
+type noopSpan struct {
+}
+
+var _ Span = noopSpan{}
+
+// SetAttribute does nothing
+func (noopSpan) SetAttribute(string, interface{}) {}
+
+// RecordError does nothing
+func (noopSpan) RecordError(error) {}
+
+// End does nothing
+func (noopSpan) End() {}
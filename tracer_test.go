@@ -0,0 +1,255 @@
+package crdtex
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordedSpan struct {
+	name       string
+	parent     string
+	attributes map[string]interface{}
+	err        error
+	ended      bool
+}
+
+type spanRecorder struct {
+	mut   sync.Mutex
+	spans []*recordedSpan
+}
+
+type spanRecorderKey struct{}
+
+type recorderSpan struct {
+	recorder *spanRecorder
+	span     *recordedSpan
+}
+
+func (r *spanRecorder) StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	parent, _ := ctx.Value(spanRecorderKey{}).(string)
+	span := &recordedSpan{
+		name:       name,
+		parent:     parent,
+		attributes: map[string]interface{}{},
+	}
+	r.spans = append(r.spans, span)
+	return context.WithValue(ctx, spanRecorderKey{}, name), recorderSpan{recorder: r, span: span}
+}
+
+func (r *spanRecorder) getSpans() []recordedSpan {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	var result []recordedSpan
+	for _, span := range r.spans {
+		result = append(result, *span)
+	}
+	return result
+}
+
+func (s recorderSpan) SetAttribute(key string, value interface{}) {
+	s.recorder.mut.Lock()
+	defer s.recorder.mut.Unlock()
+	s.span.attributes[key] = value
+}
+
+func (s recorderSpan) RecordError(err error) {
+	s.recorder.mut.Lock()
+	defer s.recorder.mut.Unlock()
+	s.span.err = err
+}
+
+func (s recorderSpan) End() {
+	s.recorder.mut.Lock()
+	defer s.recorder.mut.Unlock()
+	s.span.ended = true
+}
+
+func TestCoreService_Tracer__Sync_Round_Span(t *testing.T) {
+	t.Parallel()
+
+	tracer := &spanRecorder{}
+	methods := newCallbacksMock()
+	var updateCtx context.Context
+	methods.updateRemoteFunc = func(ctx context.Context, addr string, state State, resultChan chan<- updateResult) {
+		updateCtx = ctx
+	}
+
+	self := nodeID{
+		timestamp: 100,
+		addr:      "self-addr",
+	}
+	s := newCoreService(methods, self,
+		computeOptions(
+			AddRemoteAddress("remote-addr-1"),
+			WithTracer(tracer),
+		),
+	)
+	s.syncTimer = newTimerMock()
+	s.expireTimer = newTimerMock()
+
+	s.init(context.Background())
+	s.handleSyncTimerExpired(context.Background())
+
+	assert.Equal(t, []recordedSpan{
+		{
+			name: SpanSyncRound,
+			attributes: map[string]interface{}{
+				AttrStateSize: 1,
+				AttrPeer:      "remote-addr-1",
+				AttrLeader:    "self-addr",
+			},
+			ended: true,
+		},
+	}, tracer.getSpans())
+	assert.Equal(t, SpanSyncRound, updateCtx.Value(spanRecorderKey{}))
+}
+
+func TestInterfaceCallbacks_UpdateRemote__Span(t *testing.T) {
+	errUpdate := errors.New("update error")
+	table := []struct {
+		name     string
+		response State
+		err      error
+		expected recordedSpan
+	}{
+		{
+			name: "ok",
+			response: State{
+				"address-1": {Version: 1},
+				"address-2": {Version: 1},
+			},
+			expected: recordedSpan{
+				name:   SpanUpdateRemote,
+				parent: "parent-span",
+				attributes: map[string]interface{}{
+					AttrPeer:              "address-2",
+					AttrStateSize:         1,
+					AttrResponseStateSize: 2,
+					AttrOutcome:           "ok",
+				},
+				ended: true,
+			},
+		},
+		{
+			name: "error",
+			err:  errUpdate,
+			expected: recordedSpan{
+				name:   SpanUpdateRemote,
+				parent: "parent-span",
+				attributes: map[string]interface{}{
+					AttrPeer:      "address-2",
+					AttrStateSize: 1,
+					AttrOutcome:   "error",
+				},
+				err:   errUpdate,
+				ended: true,
+			},
+		},
+	}
+
+	for _, tc := range table {
+		e := tc
+		t.Run(e.name, func(t *testing.T) {
+			t.Parallel()
+
+			tracer := &spanRecorder{}
+			c := interfaceCallbacks{
+				iface: updateRemoteStub{
+					response: e.response,
+					err:      e.err,
+				},
+				callRemoteTimeout: time.Second,
+				tracer:            tracer,
+			}
+
+			ctx := context.WithValue(context.Background(), spanRecorderKey{}, "parent-span")
+			resultChan := make(chan updateResult, 1)
+			c.updateRemote(ctx, "address-2", State{"address-1": {Version: 1}}, resultChan)
+
+			result := <-resultChan
+			assert.False(t, result.sentAt.IsZero())
+			result.sentAt = time.Time{}
+			assert.Equal(t, updateResult{addr: "address-2", state: e.response, err: e.err}, result)
+			assert.Equal(t, []recordedSpan{e.expected}, tracer.getSpans())
+		})
+	}
+}
+
+type updateRemoteStub struct {
+	response State
+	err      error
+}
+
+func (s updateRemoteStub) Start(context.Context) {}
+
+func (s updateRemoteStub) UpdateRemote(context.Context, string, State) (State, error) {
+	return s.response, s.err
+}
+
+func (s updateRemoteStub) AntiEntropy(context.Context, string, AntiEntropyRequest) (AntiEntropyResponse, error) {
+	return AntiEntropyResponse{State: s.response}, s.err
+}
+
+func TestInterfaceCallbacks_AntiEntropy__Span(t *testing.T) {
+	t.Parallel()
+
+	errExchange := errors.New("exchange error")
+	tracer := &spanRecorder{}
+	c := interfaceCallbacks{
+		iface:             updateRemoteStub{err: errExchange},
+		callRemoteTimeout: time.Second,
+		tracer:            tracer,
+	}
+
+	ctx := context.WithValue(context.Background(), spanRecorderKey{}, "parent-span")
+	resultChan := make(chan updateResult, 1)
+	c.antiEntropy(ctx, "address-2", State{"address-1": {Version: 1}}, resultChan)
+
+	result := <-resultChan
+	assert.Equal(t, errExchange, result.err)
+	assert.Equal(t, []recordedSpan{
+		{
+			name:   SpanAntiEntropy,
+			parent: "parent-span",
+			attributes: map[string]interface{}{
+				AttrPeer:      "address-2",
+				AttrStateSize: 1,
+				AttrOutcome:   "error",
+			},
+			err:   errExchange,
+			ended: true,
+		},
+	}, tracer.getSpans())
+
+	tracer = &spanRecorder{}
+	c.tracer = tracer
+	c.iface = updateRemoteStub{response: State{
+		"address-1": {Version: 2},
+		"address-3": {Version: 1},
+	}}
+	c.antiEntropy(ctx, "address-2", State{"address-1": {Version: 1}}, resultChan)
+
+	result = <-resultChan
+	assert.Equal(t, nil, result.err)
+	assert.Equal(t, []recordedSpan{
+		{
+			name:   SpanAntiEntropy,
+			parent: "parent-span",
+			attributes: map[string]interface{}{
+				AttrPeer:              "address-2",
+				AttrStateSize:         1,
+				AttrResponseStateSize: 2,
+				AttrOutcome:           "ok",
+			},
+			ended: true,
+		},
+	}, tracer.getSpans())
+}
@@ -0,0 +1,269 @@
+package crdtex
+
+import "math/bits"
+
+const (
+	trieBits = 5
+	trieMask = 1<<trieBits - 1
+
+	// hashBits is the number of bits of the hash of a key, the nodes below are lists of collisions
+	hashBits = 64
+)
+
+// stateTrie is a persistent hash array mapped trie of the entries of a state.
+// A change copies only the nodes on the path to the changed entry, the other nodes are shared
+// with the previous versions, which are never modified
+type stateTrie struct {
+	root *trieNode
+	size int
+}
+
+// trieNode is a sparse array of the slots indexed by trieBits bits of the hash of their keys.
+// Below hashBits, a node is a list of leaves whose keys have the same hash
+type trieNode struct {
+	bitmap uint32
+	slots  []trieSlot
+}
+
+// trieSlot holds either a leaf or a child node
+type trieSlot struct {
+	leaf *trieLeaf
+	node *trieNode
+}
+
+type trieLeaf struct {
+	hash  uint64
+	key   string
+	entry Entry
+}
+
+var emptyTrieNode = &trieNode{}
+
+// hashKey is the 64-bit FNV-1a hash of key
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+func trieBit(hash uint64, shift uint) uint32 {
+	return 1 << ((hash >> shift) & trieMask)
+}
+
+func (n *trieNode) position(bit uint32) int {
+	return bits.OnesCount32(n.bitmap & (bit - 1))
+}
+
+// newStateTrie creates a trie with the entries of state
+func newStateTrie(state State) stateTrie {
+	var t stateTrie
+	for k, v := range state {
+		t = t.putEntry(k, v)
+	}
+	return t
+}
+
+func (t stateTrie) len() int {
+	return t.size
+}
+
+func (t stateTrie) get(key string) (Entry, bool) {
+	hash := hashKey(key)
+	n := t.root
+	for shift := uint(0); n != nil; shift += trieBits {
+		if shift >= hashBits {
+			return n.getCollision(key)
+		}
+		bit := trieBit(hash, shift)
+		if n.bitmap&bit == 0 {
+			return Entry{}, false
+		}
+		slot := n.slots[n.position(bit)]
+		if slot.node == nil {
+			if slot.leaf.key != key {
+				return Entry{}, false
+			}
+			return slot.leaf.entry, true
+		}
+		n = slot.node
+	}
+	return Entry{}, false
+}
+
+func (n *trieNode) getCollision(key string) (Entry, bool) {
+	for _, slot := range n.slots {
+		if slot.leaf.key == key {
+			return slot.leaf.entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// entry returns the entry of key, the zero Entry if it does not exist
+func (t stateTrie) entry(key string) Entry {
+	e, _ := t.get(key)
+	return e
+}
+
+// putEntry returns the trie with the entry of key set
+func (t stateTrie) putEntry(key string, entry Entry) stateTrie {
+	leaf := &trieLeaf{hash: hashKey(key), key: key, entry: entry}
+	root, added := t.root.put(leaf, 0)
+	if added {
+		t.size++
+	}
+	t.root = root
+	return t
+}
+
+func (n *trieNode) put(leaf *trieLeaf, shift uint) (*trieNode, bool) {
+	if n == nil {
+		n = emptyTrieNode
+	}
+	if shift >= hashBits {
+		return n.putCollision(leaf)
+	}
+
+	bit := trieBit(leaf.hash, shift)
+	pos := n.position(bit)
+	if n.bitmap&bit == 0 {
+		slots := make([]trieSlot, len(n.slots)+1)
+		copy(slots, n.slots[:pos])
+		slots[pos] = trieSlot{leaf: leaf}
+		copy(slots[pos+1:], n.slots[pos:])
+		return &trieNode{bitmap: n.bitmap | bit, slots: slots}, true
+	}
+
+	slot := n.slots[pos]
+	switch {
+	case slot.node != nil:
+		child, added := slot.node.put(leaf, shift+trieBits)
+		return n.withSlot(pos, trieSlot{node: child}), added
+
+	case slot.leaf.key == leaf.key:
+		return n.withSlot(pos, trieSlot{leaf: leaf}), false
+
+	default:
+		child, _ := emptyTrieNode.put(slot.leaf, shift+trieBits)
+		child, _ = child.put(leaf, shift+trieBits)
+		return n.withSlot(pos, trieSlot{node: child}), true
+	}
+}
+
+func (n *trieNode) putCollision(leaf *trieLeaf) (*trieNode, bool) {
+	for i, slot := range n.slots {
+		if slot.leaf.key == leaf.key {
+			return n.withSlot(i, trieSlot{leaf: leaf}), false
+		}
+	}
+	slots := make([]trieSlot, len(n.slots)+1)
+	copy(slots, n.slots)
+	slots[len(n.slots)] = trieSlot{leaf: leaf}
+	return &trieNode{slots: slots}, true
+}
+
+func (n *trieNode) withSlot(pos int, slot trieSlot) *trieNode {
+	slots := make([]trieSlot, len(n.slots))
+	copy(slots, n.slots)
+	slots[pos] = slot
+	return &trieNode{bitmap: n.bitmap, slots: slots}
+}
+
+// each calls fn with every entry of the trie
+func (t stateTrie) each(fn func(key string, e Entry)) {
+	t.root.each(fn)
+}
+
+func (n *trieNode) each(fn func(key string, e Entry)) {
+	if n == nil {
+		return
+	}
+	for _, slot := range n.slots {
+		if slot.node != nil {
+			slot.node.each(fn)
+			continue
+		}
+		fn(slot.leaf.key, slot.leaf.entry)
+	}
+}
+
+// find calls fn with the entries of the trie until it returns true
+func (t stateTrie) find(fn func(key string, e Entry) bool) bool {
+	return t.root.find(fn)
+}
+
+func (n *trieNode) find(fn func(key string, e Entry) bool) bool {
+	if n == nil {
+		return false
+	}
+	for _, slot := range n.slots {
+		if slot.node != nil {
+			if slot.node.find(fn) {
+				return true
+			}
+			continue
+		}
+		if fn(slot.leaf.key, slot.leaf.entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// eachChangedSince calls fn with the entries of the trie written after old,
+// the nodes shared with old are skipped
+func (t stateTrie) eachChangedSince(old stateTrie, fn func(key string, e Entry)) {
+	t.root.eachChangedSince(old.root, 0, fn)
+}
+
+func (n *trieNode) eachChangedSince(old *trieNode, shift uint, fn func(key string, e Entry)) {
+	if n == old {
+		return
+	}
+	if old == nil || shift >= hashBits {
+		n.each(fn)
+		return
+	}
+	remaining := n.bitmap
+	for _, slot := range n.slots {
+		bit := remaining & -remaining
+		remaining &^= bit
+
+		var oldSlot trieSlot
+		if old.bitmap&bit != 0 {
+			oldSlot = old.slots[old.position(bit)]
+		}
+		switch {
+		case slot.node != nil:
+			slot.node.eachChangedSince(oldSlot.node, shift+trieBits, fn)
+		case slot.leaf != oldSlot.leaf:
+			fn(slot.leaf.key, slot.leaf.entry)
+		}
+	}
+}
+
+// mergeState returns the trie with the entries of b greater than the ones of the trie, and their keys
+func (t stateTrie) mergeState(b State) (stateTrie, []string) {
+	var changed []string
+	for k, v := range b {
+		previous, existed := t.get(k)
+		if existed && !entryLess(previous, v) {
+			continue
+		}
+		changed = append(changed, k)
+		t = t.putEntry(k, v)
+	}
+	return t, changed
+}
+
+// toState returns a new State with the entries of the trie
+func (t stateTrie) toState() State {
+	result := make(State, t.size)
+	t.each(func(key string, e Entry) {
+		result[key] = e
+	})
+	return result
+}
@@ -0,0 +1,128 @@
+package crdtex
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestStateTrie_Put_And_Get(t *testing.T) {
+	t.Parallel()
+
+	var empty stateTrie
+	_, ok := empty.get("addr-1")
+	assert.Equal(t, false, ok)
+
+	a := empty.putEntry("addr-1", Entry{Term: 1, Version: 1})
+	b := a.putEntry("addr-2", Entry{Term: 1, Version: 2})
+	c := b.putEntry("addr-1", Entry{Term: 1, Version: 3})
+
+	assert.Equal(t, State{"addr-1": {Term: 1, Version: 1}}, a.toState())
+	assert.Equal(t, State{"addr-1": {Term: 1, Version: 1}, "addr-2": {Term: 1, Version: 2}}, b.toState())
+	assert.Equal(t, State{"addr-1": {Term: 1, Version: 3}, "addr-2": {Term: 1, Version: 2}}, c.toState())
+	assert.Equal(t, 1, a.len())
+	assert.Equal(t, 2, b.len())
+	assert.Equal(t, 2, c.len())
+
+	e, ok := c.get("addr-1")
+	assert.Equal(t, true, ok)
+	assert.Equal(t, Entry{Term: 1, Version: 3}, e)
+	assert.Equal(t, Entry{}, c.entry("unknown"))
+}
+
+func TestStateTrie_Random(t *testing.T) {
+	t.Parallel()
+
+	rnd := rand.New(rand.NewSource(1))
+	expected := State{}
+	var trie stateTrie
+	for i := 0; i < 5000; i++ {
+		key := fmt.Sprintf("node-%d", rnd.Intn(2000))
+		e := Entry{Term: 1, Version: uint64(i)}
+		expected[key] = e
+		trie = trie.putEntry(key, e)
+	}
+
+	assert.Equal(t, len(expected), trie.len())
+	assert.Equal(t, expected, trie.toState())
+	for key, e := range expected {
+		assert.Equal(t, e, trie.entry(key))
+	}
+	assert.Equal(t, trie.toState(), newStateTrie(expected).toState())
+}
+
+func TestStateTrie_Hash_Collisions(t *testing.T) {
+	t.Parallel()
+
+	// the leaves are put with the same hash
+	root := emptyTrieNode
+	var added []bool
+	for i, key := range []string{"key-1", "key-2", "key-3", "key-2"} {
+		var ok bool
+		root, ok = root.put(&trieLeaf{hash: 42, key: key, entry: Entry{Version: uint64(i)}}, 0)
+		added = append(added, ok)
+	}
+	assert.Equal(t, []bool{true, true, true, false}, added)
+
+	result := State{}
+	root.each(func(key string, e Entry) {
+		result[key] = e
+	})
+	assert.Equal(t, State{
+		"key-1": {Version: 0},
+		"key-2": {Version: 3},
+		"key-3": {Version: 2},
+	}, result)
+}
+
+func changedKeys(trie, old stateTrie) []string {
+	var keys []string
+	trie.eachChangedSince(old, func(key string, _ Entry) {
+		keys = append(keys, key)
+	})
+	sort.Strings(keys)
+	return keys
+}
+
+func TestStateTrie_Each_Changed_Since(t *testing.T) {
+	t.Parallel()
+
+	old := newStateTrie(newBenchState(1000))
+	assert.Equal(t, []string(nil), changedKeys(old, old))
+
+	changed := old.putEntry("node-5", Entry{Term: 1, Timestamp: 6, Version: 2})
+	changed = changed.putEntry("node-700", Entry{Term: 1, Timestamp: 701, Version: 2})
+	changed = changed.putEntry("new-node", Entry{Term: 1, Timestamp: 2000, Version: 1})
+	assert.Equal(t, []string{"new-node", "node-5", "node-700"}, changedKeys(changed, old))
+
+	assert.Equal(t, 1000, len(changedKeys(old, stateTrie{})))
+}
+
+func TestStateTrie_Merge_State(t *testing.T) {
+	t.Parallel()
+
+	trie := newStateTrie(State{
+		"addr-1": {Term: 1, Version: 2},
+		"addr-2": {Term: 1, Version: 2},
+	})
+
+	result, changed := trie.mergeState(State{
+		"addr-1": {Term: 1, Version: 1},
+		"addr-2": {Term: 1, Version: 3},
+		"addr-3": {Term: 1, Version: 1},
+	})
+	sort.Strings(changed)
+	assert.Equal(t, []string{"addr-2", "addr-3"}, changed)
+	assert.Equal(t, State{
+		"addr-1": {Term: 1, Version: 2},
+		"addr-2": {Term: 1, Version: 3},
+		"addr-3": {Term: 1, Version: 1},
+	}, result.toState())
+
+	// unchanged, the same nodes are returned
+	same, changed := result.mergeState(State{"addr-1": {Term: 1, Version: 2}})
+	assert.Equal(t, []string(nil), changed)
+	assert.True(t, same.root == result.root)
+}